@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/jtagcat/teinetahvel/tahvel"
+	"go.etcd.io/bbolt"
+)
+
+// badSearch is user error in search parameters, in Estonian.
+type badSearch string
+
+func (e badSearch) Error() string {
+	return string(e)
+}
+
+// bookCandidate is a room and time to try booking.
+type bookCandidate struct {
+	Room tahvel.Room
+	tahvel.Interval
+}
+
+// searchCandidates runs a search (as in /search query) on date,
+// returning what to book, best first.
+func searchCandidates(ctx context.Context, db *bbolt.DB, t *tahvel.Tahvel, user *tahvel.User, query url.Values, date time.Time) ([]bookCandidate, error) {
+	want, length, err := bookableSearch(query, date)
+	if err != nil {
+		return nil, err
+	}
+
+	rooms, err := t.GetRooms(ctx, date)
+	if err != nil {
+		return nil, err
+	}
+
+	return pickCandidates(db, t, user, rooms, query, want, length), nil
+}
+
+// bookableSearch parses the searched time, which must have an end or length.
+func bookableSearch(query url.Values, date time.Time) (want tahvel.Interval, length time.Duration, _ error) {
+	want, err := searchInterval(date, query.Get("startTime"), query.Get("stopTime"))
+	if err != nil {
+		return want, 0, badSearch("Vigane algus- või lõpuaeg")
+	}
+	length, err = searchLength(query.Get("length"))
+	if err != nil {
+		return want, 0, badSearch("Vigane kestus")
+	}
+	if length == 0 && want.End.IsZero() {
+		return want, 0, badSearch("Broneerimiseks on vaja lõpuaega või kestust")
+	}
+
+	return want, length, nil
+}
+
+func pickCandidates(db *bbolt.DB, t *tahvel.Tahvel, user *tahvel.User, rooms []tahvel.Room,
+	query url.Values, want tahvel.Interval, length time.Duration,
+) (candidates []bookCandidate) {
+	needs := searchNeeds(query)
+
+	if length != 0 {
+		for _, slot := range tahvel.FindSlots(db, rooms, user.Roles, needs, slotWindow(want, t.Now()), length) {
+			candidates = append(candidates, bookCandidate{Room: slot.Room, Interval: slot.Interval})
+		}
+
+		return
+	}
+
+	good, _, _ := tahvel.FilterRooms(db, rooms, user.Roles, needs, want, OVERLAP_TOLERANCE)
+	tahvel.Rank(good, want, needs, favouriteSet(db, user.UserId))
+	for _, r := range good {
+		candidates = append(candidates, bookCandidate{Room: r, Interval: want})
+	}
+
+	return
+}
+
+// fallbackCandidates are rooms like failed, free at iv, best first.
+// Like is search (the /search query the booking came from), or else having a piano if failed has.
+func fallbackCandidates(ctx context.Context, db *bbolt.DB, t *tahvel.Tahvel, user *tahvel.User, search string, failed int, iv tahvel.Interval) ([]bookCandidate, error) {
+	rooms, err := t.GetRooms(ctx, iv.Start)
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := url.ParseQuery(search)
+	if err != nil || len(query) == 0 {
+		query = make(url.Values)
+		if i := slices.IndexFunc(rooms, func(r tahvel.Room) bool { return r.Id == failed }); i != -1 && rooms[i].PianoCount > 0 {
+			query.Set("needsPiano", "needsPiano")
+		}
+	}
+
+	candidates := pickCandidates(db, t, user, rooms, query, iv, 0)
+
+	return slices.DeleteFunc(candidates, func(c bookCandidate) bool { return c.Room.Id == failed }), nil
+}
+
+// how many candidates bookFirst tries, each is a request to Tahvel
+const BOOK_TRIES = 3
+
+// bookFirst books the first candidate Tahvel accepts,
+// moving on if it was taken or forbidden meanwhile.
+func bookFirst(ctx context.Context, t *tahvel.Tahvel, candidates []bookCandidate) (bookCandidate, error) {
+	if len(candidates) == 0 {
+		return bookCandidate{}, badSearch("Sobivat vaba ruumi ei leitud")
+	}
+
+	var err error
+	for i, candidate := range candidates {
+		if i == BOOK_TRIES {
+			break
+		}
+
+		err = t.CreateBooking(ctx, []int{candidate.Room.Id}, candidate.Interval)
+		if err == nil {
+			return candidate, nil
+		}
+
+		if !errors.Is(err, tahvel.ErrOccupied) && !errors.Is(err, tahvel.ErrForbidden) {
+			return bookCandidate{}, err
+		}
+	}
+
+	return bookCandidate{}, err
+}
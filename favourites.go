@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jtagcat/teinetahvel/tahvel"
+	ginutil "github.com/jtagcat/util/gin"
+	"go.etcd.io/bbolt"
+)
+
+// favourites are room Ids, in the order starred
+func favourites(db *bbolt.DB, userId int) (ids []int, _ error) {
+	return ids, db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket([]byte("favourite_rooms")).Get([]byte(strconv.Itoa(userId)))
+		if v == nil {
+			return nil
+		}
+
+		return json.Unmarshal(v, &ids)
+	})
+}
+
+func favouriteSet(db *bbolt.DB, userId int) map[int]bool {
+	ids, _ := favourites(db, userId)
+
+	set := make(map[int]bool)
+	for _, id := range ids {
+		set[id] = true
+	}
+
+	return set
+}
+
+func setFavourite(db *bbolt.DB, userId, roomId int, on bool) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("favourite_rooms"))
+		key := []byte(strconv.Itoa(userId))
+
+		var ids []int
+		if v := b.Get(key); v != nil {
+			if err := json.Unmarshal(v, &ids); err != nil {
+				return err
+			}
+		}
+
+		ids = slices.DeleteFunc(ids, func(id int) bool { return id == roomId })
+		if on {
+			ids = append(ids, roomId)
+		}
+
+		idsJ, err := json.Marshal(ids)
+		if err != nil {
+			return err
+		}
+
+		return b.Put(key, idsJ)
+	})
+}
+
+type favouriteRoom struct {
+	tahvel.Room
+	FreeAtWant bool
+}
+
+// favouriteRooms are the user's favourites in rooms, in starred order.
+// Zero want leaves FreeAtWant false.
+func favouriteRooms(ids []int, rooms []tahvel.Room, want tahvel.Interval) (favs []favouriteRoom) {
+	for _, id := range ids {
+		i := slices.IndexFunc(rooms, func(r tahvel.Room) bool { return r.Id == id })
+		if i == -1 {
+			continue
+		}
+
+		r := rooms[i]
+		free := want.Valid() && r.FreeAt(want, OVERLAP_TOLERANCE)
+		favs = append(favs, favouriteRoom{Room: r, FreeAtWant: free})
+	}
+
+	return
+}
+
+// back is the local page the request came from, or fallback.
+func back(c *gin.Context, fallback string) string {
+	ref, err := url.Parse(c.Request.Referer())
+	if err != nil || ref.Host != c.Request.Host || !strings.HasPrefix(ref.Path, "/") {
+		return fallback
+	}
+
+	return ref.RequestURI()
+}
+
+func favouriteHandlers(gctx context.Context, r *gin.Engine, db *bbolt.DB, client *tahvel.Client) {
+	r.GET("/favourite", ginutil.HandlerWithErr(func(c *gin.Context, g *ginutil.Context) (int, string) {
+		if !authed(c) {
+			return g.Redirect(http.StatusTemporaryRedirect, "/")
+		}
+
+		t := client.Session(g.Cookie("session"))
+		ctx, cancel := context.WithTimeout(gctx, 5*time.Second)
+		defer cancel()
+
+		user, status, errStr := sessionUser(ctx, c, db, t)
+		if user == nil {
+			return status, errStr
+		}
+
+		roomId, err := strconv.Atoi(c.Query("room"))
+		if err != nil {
+			return http.StatusBadRequest, "Vigane ruum"
+		}
+
+		if err := setFavourite(db, user.UserId, roomId, c.Query("on") == "1"); err != nil {
+			return http.StatusInternalServerError, err.Error()
+		}
+
+		return g.Redirect(http.StatusTemporaryRedirect, back(c, "/search"))
+	}))
+}
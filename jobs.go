@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/jtagcat/teinetahvel/tahvel"
+	bb "github.com/jtagcat/util/bbolt"
+	"go.etcd.io/bbolt"
+)
+
+// Background jobs (recurring rules, waitlist entries) act as the user with their stored session.
+// Each is stored as JSON by its Id, in a bucket shared by all users.
+
+const SESSION_EXPIRED = "Sessioon on aegunud, logi uuesti sisse."
+
+var errJobNotFound = errors.New("not found")
+
+func listJobs[T any](db *bbolt.DB, bucket string) (jobs []T, _ error) {
+	return jobs, db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(bucket)).ForEach(func(_, v []byte) error {
+			var job T
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+
+			jobs = append(jobs, job)
+			return nil
+		})
+	})
+}
+
+// putJob creates or updates the job.
+// With mustExist, a job deleted in the meanwhile is not recreated.
+func putJob(db *bbolt.DB, bucket, id string, job any, mustExist bool) error {
+	jobJ, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if mustExist && b.Get([]byte(id)) == nil {
+			return nil
+		}
+
+		return b.Put([]byte(id), jobJ)
+	})
+}
+
+// deleteJob deletes the job, if it belongs to userId.
+func deleteJob(db *bbolt.DB, bucket string, userId int, id string) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+
+		var job struct{ UserId int }
+		if err := json.Unmarshal(b.Get([]byte(id)), &job); err != nil || job.UserId != userId {
+			return errJobNotFound
+		}
+
+		return b.Delete([]byte(id))
+	})
+}
+
+// every calls run, and again after each interval, until ctx is done.
+func every(ctx context.Context, interval time.Duration, run func()) {
+	for {
+		run()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+//
+
+// jobSessions are the stored sessions, as used during one run of jobs.
+type jobSessions struct {
+	db     *bbolt.DB
+	client *tahvel.Client
+	users  map[int]*tahvel.User // by UserId, nil if the session has expired
+}
+
+func newJobSessions(db *bbolt.DB, client *tahvel.Client) *jobSessions {
+	return &jobSessions{db: db, client: client, users: make(map[int]*tahvel.User)}
+}
+
+// get returns the user's session, and the user if the session is alive.
+// Checked once per run, which also keeps the session from timing out.
+func (s *jobSessions) get(ctx context.Context, userId int) (*tahvel.Tahvel, *tahvel.User) {
+	t := s.client.Session(bb.Get(s.db, []byte("sessions"), strconv.Itoa(userId)))
+
+	user, ok := s.users[userId]
+	if !ok {
+		user, _ = t.GetUser(ctx)
+		s.users[userId] = user
+	}
+
+	return t, user
+}
+
+// expired skips the user's remaining jobs, after one got tahvel.ErrSessionExpired.
+func (s *jobSessions) expired(userId int) {
+	s.users[userId] = nil
+}
+
+//
+
+// rememberSession stores the session (in plaintext),
+// for recurring bookings and the waitlist to act as the user.
+func rememberSession(db *bbolt.DB, userId int, session string) error {
+	return bb.Put(db, []byte("sessions"), strconv.Itoa(userId), session)
+}
+
+// hasJobs is if the user has recurring rules or is waiting on the waitlist.
+func hasJobs(db *bbolt.DB, userId int) (bool, error) {
+	rules, err := userRecurringRules(db, userId)
+	if err != nil {
+		return false, err
+	}
+	entries, err := userWaitlist(db, userId)
+	if err != nil {
+		return false, err
+	}
+
+	return len(rules) != 0 || slices.ContainsFunc(entries, func(w waitlistEntry) bool { return w.waiting() }), nil
+}
+
+// forgetSession deletes the stored session, once the user has no background jobs left.
+func forgetSession(db *bbolt.DB, userId int) error {
+	if has, err := hasJobs(db, userId); err != nil || has {
+		return err
+	}
+
+	return db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte("sessions")).Delete([]byte(strconv.Itoa(userId)))
+	})
+}
+
+// deleteSession deletes session wherever it is stored, on logging out.
+func deleteSession(db *bbolt.DB, session string) error {
+	if session == "" {
+		return nil
+	}
+
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("sessions"))
+
+		var users [][]byte
+		if err := b.ForEach(func(k, v []byte) error {
+			if string(v) == session {
+				users = append(users, k)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, k := range users {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
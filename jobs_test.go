@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestJobs(t *testing.T) {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "teinetahvel.db"), 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := createBuckets(db); err != nil {
+		t.Fatal(err)
+	}
+
+	entry := waitlistEntry{Id: "a", UserId: 1, Date: "2026-10-20"}
+	if err := putJob(db, "waitlist", entry.Id, entry, true); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := waitlistEntries(db); len(entries) != 0 {
+		t.Fatal("created with mustExist")
+	}
+
+	if err := putJob(db, "waitlist", entry.Id, entry, false); err != nil {
+		t.Fatal(err)
+	}
+	entry.Found = "D108 10:00 - 11:00"
+	if err := putJob(db, "waitlist", entry.Id, entry, true); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := waitlistEntries(db); len(entries) != 1 || entries[0].Found != entry.Found {
+		t.Fatalf("got %v, want the updated entry", entries)
+	}
+
+	if err := deleteJob(db, "waitlist", 2, entry.Id); !errors.Is(err, errJobNotFound) {
+		t.Fatalf("deleted another user's job: %v", err)
+	}
+	if err := deleteJob(db, "waitlist", 1, entry.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteJob(db, "waitlist", 1, entry.Id); !errors.Is(err, errJobNotFound) {
+		t.Fatalf("deleted twice: %v", err)
+	}
+}
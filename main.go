@@ -8,14 +8,18 @@ import (
 	"log/slog"
 	"maps"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jtagcat/teinetahvel/tahvel"
+	"github.com/jtagcat/teinetahvel/tahveltest"
 	bb "github.com/jtagcat/util/bbolt"
 	ginutil "github.com/jtagcat/util/gin"
 	"github.com/jtagcat/util/std"
@@ -24,12 +28,25 @@ import (
 )
 
 var (
-	TIMEZONE, _ = time.LoadLocation("Europe/Tallinn")
 	FOOTER_HTML = os.Getenv("FOOTER_HTML")
 	TITLE       = os.Getenv("TITLE")
+
+	// bookings overlapping the search by this much are not conflicts
+	OVERLAP_TOLERANCE = time.Minute
+)
+
+const (
+	MAX_SLOTS = 20
+
+	// for "now" bookings, see /book/now
+	QUICKBOOK_LENGTH = 45 * time.Minute
 )
 
-var AUTHSESSIONS = make(map[string]string)
+// pending logins, authSession: Tahvel session once confirmed
+var (
+	AUTHSESSIONS   = make(map[string]string)
+	authSessionsMu sync.Mutex
+)
 
 func init() {
 	go func() {
@@ -38,6 +55,7 @@ func init() {
 
 			expireLine := time.Now().Add(-3 * time.Minute)
 
+			authSessionsMu.Lock()
 			for id := range AUTHSESSIONS {
 				xid, err := xid.FromString(id)
 				if err != nil {
@@ -49,6 +67,7 @@ func init() {
 					delete(AUTHSESSIONS, id)
 				}
 			}
+			authSessionsMu.Unlock()
 		}
 	}()
 }
@@ -66,8 +85,11 @@ func main() {
 	} else {
 		gin.SetMode(gin.ReleaseMode)
 	}
-	router := gin.Default()
-	router.LoadHTMLGlob("templates/*.html")
+
+	if tahvel.BOOKINGNAME == "" {
+		slog.Error("booking name must not be empty", slog.String("environment", "BOOKINGNAME"))
+		os.Exit(1)
+	}
 
 	db, err := bbolt.Open("data/teinetahvel.db", 0o600, nil)
 	if err != nil {
@@ -76,8 +98,74 @@ func main() {
 	}
 	defer db.Close()
 
-	if err := db.Update(func(tx *bbolt.Tx) error {
-		for _, bucket := range []string{"crowdsourced_room_acl"} {
+	if err := createBuckets(db); err != nil {
+		slog.Error("adding buckets to database", std.SlogErr(err))
+		os.Exit(1)
+	}
+
+	var clientOpts []tahvel.Option
+	if url := os.Getenv("TAHVEL_URL"); url != "" {
+		clientOpts = append(clientOpts, tahvel.WithBaseURL(url))
+	}
+	if os.Getenv("TAHVEL_FAKE") == "1" {
+		fake := tahveltest.NewServer()
+		defer fake.Close()
+
+		fake.AuthDelay = 3 * time.Second
+		fake.Seed()
+
+		clientOpts = append(clientOpts, tahvel.WithBaseURL(fake.URL))
+	}
+	if ua := os.Getenv("TAHVEL_USER_AGENT"); ua != "" {
+		clientOpts = append(clientOpts, tahvel.WithUserAgent(ua))
+	}
+	if timeoutStr := os.Getenv("TAHVEL_TIMEOUT"); timeoutStr != "" {
+		timeout, err := time.ParseDuration(timeoutStr)
+		if err != nil {
+			slog.Error("parsing TAHVEL_TIMEOUT", std.SlogErr(err))
+			os.Exit(1)
+		}
+		clientOpts = append(clientOpts, tahvel.WithTimeout(timeout))
+	}
+	if toleranceStr := os.Getenv("OVERLAP_TOLERANCE"); toleranceStr != "" {
+		tolerance, err := time.ParseDuration(toleranceStr)
+		if err != nil {
+			slog.Error("parsing OVERLAP_TOLERANCE", std.SlogErr(err))
+			os.Exit(1)
+		}
+		OVERLAP_TOLERANCE = tolerance
+	}
+	if tz := os.Getenv("TIMEZONE"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			slog.Error("loading TIMEZONE", std.SlogErr(err))
+			os.Exit(1)
+		}
+		clientOpts = append(clientOpts, tahvel.WithLocation(loc))
+	}
+	if daysStr := os.Getenv("TAHVEL_BOOKING_DAYS"); daysStr != "" {
+		days, err := strconv.Atoi(daysStr)
+		if err != nil {
+			slog.Error("parsing TAHVEL_BOOKING_DAYS", std.SlogErr(err))
+			os.Exit(1)
+		}
+		clientOpts = append(clientOpts, tahvel.WithBookingHorizon(days))
+	}
+	client := tahvel.New(clientOpts...)
+	slog.Info("using Tahvel", slog.String("url", client.BaseURL()))
+
+	router := newRouter(ctx, db, client)
+
+	go every(ctx, RECURRING_INTERVAL, func() { runRecurring(ctx, db, client) })
+	go every(ctx, WAITLIST_INTERVAL, func() { runWaitlist(ctx, db, client) })
+
+	ginutil.RunWithContext(ctx, router)
+}
+
+// createBuckets creates missing buckets.
+func createBuckets(db *bbolt.DB) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range []string{"crowdsourced_room_acl", "sessions", "recurring_rules", "presets", "favourite_rooms", "user_settings", "waitlist"} {
 			if _, err := tx.CreateBucket([]byte(bucket)); err != nil {
 				if !errors.Is(err, bbolt.ErrBucketExists) {
 					return err
@@ -86,16 +174,25 @@ func main() {
 		}
 
 		return nil
-	}); err != nil {
-		slog.Error("adding buckets to database", std.SlogErr(err))
-		os.Exit(1)
-	}
+	})
+}
 
-	authHandlers(ctx, router)
-	mainHandlers(ctx, router, db)
-	bookingHandlers(ctx, router)
+func newRouter(ctx context.Context, db *bbolt.DB, client *tahvel.Client) *gin.Engine {
+	router := gin.Default()
+	router.SetFuncMap(template.FuncMap{"bookLink": newBookLink})
+	router.LoadHTMLGlob("templates/*.html")
 
-	ginutil.RunWithContext(ctx, router)
+	authHandlers(ctx, router, db, client)
+	mainHandlers(ctx, router, db, client)
+	bookingHandlers(ctx, router, db, client)
+	recurringHandlers(ctx, router, db, client)
+	timelineHandlers(ctx, router, db, client)
+	presetHandlers(ctx, router, db, client)
+	favouriteHandlers(ctx, router, db, client)
+	settingsHandlers(ctx, router, db, client)
+	waitlistHandlers(ctx, router, db, client)
+
+	return router
 }
 
 func authed(c *gin.Context) bool {
@@ -106,26 +203,123 @@ func authed(c *gin.Context) bool {
 	return true
 }
 
-func authHandlers(gctx context.Context, r *gin.Engine) {
+// upstreamError maps errors from Tahvel to a HandlerWithErr response.
+func upstreamError(c *gin.Context, doing string, err error) (int, string) {
+	slog.Warn("upstream error", slog.String("doing", doing), std.SlogErr(err))
+
+	var details string
+	var tErr *tahvel.Error
+	if errors.As(err, &tErr) && tErr.Message != "" {
+		details = " (Tahvel: " + tErr.Message + ")"
+		if tErr.Status == 0 { // not sent to Tahvel
+			details = " (" + tErr.Message + ")"
+		}
+	}
+
+	switch {
+	case errors.Is(err, tahvel.ErrSessionExpired):
+		c.SetCookie("session", "", -1, "", "", !gin.IsDebugging(), true)
+		return http.StatusUnauthorized, SESSION_EXPIRED
+	case errors.Is(err, tahvel.ErrForbidden):
+		return http.StatusForbidden, "Tahvel keelas, sul pole sellele ligipääsu." + details
+	case errors.Is(err, tahvel.ErrStale):
+		return http.StatusConflict, "Broneeringut on vahepeal mujal muudetud, laadi leht uuesti."
+	case errors.Is(err, tahvel.ErrOccupied):
+		return http.StatusConflict, "Aeg on juba kinni, kas ruum broneeriti vahetult enne ära?" + details
+	case errors.Is(err, tahvel.ErrValidation):
+		return http.StatusUnprocessableEntity, "Tahvel ei nõustunud päringuga." + details
+	case errors.Is(err, tahvel.ErrUnavailable):
+		return http.StatusBadGateway, "Tahvel ei vasta, proovi hiljem uuesti." + details
+	}
+
+	return http.StatusBadGateway, doing + ": " + err.Error()
+}
+
+// horizonError is the response to a date refused by client.CheckHorizon.
+func horizonError(client *tahvel.Client) (int, string) {
+	return http.StatusBadRequest, fmt.Sprintf("Broneerida saab tänasest kuni %d päeva ette.", client.BookingHorizon())
+}
+
+// sessionUser refreshes the session cookie, and the session remembered for background jobs, if any.
+// If user is nil, return status and errStr.
+// An expired session is cleared and redirected to /.
+func sessionUser(ctx context.Context, c *gin.Context, db *bbolt.DB, t *tahvel.Tahvel) (user *tahvel.User, status int, errStr string) {
+	user, err := t.GetUser(ctx)
+	if err != nil {
+		if errors.Is(err, tahvel.ErrSessionExpired) {
+			c.SetCookie("session", "", -1, "", "", !gin.IsDebugging(), true)
+			c.Redirect(http.StatusTemporaryRedirect, "/")
+			return nil, 0, ""
+		}
+
+		status, errStr = upstreamError(c, "getting user", err)
+		return nil, status, errStr
+	}
+	c.SetCookie("session", t.Session, user.SessionTimeoutInSeconds, "", "", !gin.IsDebugging(), true)
+
+	// also after logging in again
+	if bb.Get(db, []byte("sessions"), strconv.Itoa(user.UserId)) != t.Session {
+		if has, err := hasJobs(db, user.UserId); err != nil {
+			slog.Warn("listing background jobs", std.SlogErr(err))
+		} else if has {
+			if err := rememberSession(db, user.UserId, t.Session); err != nil {
+				slog.Warn("remembering session", std.SlogErr(err))
+			}
+		}
+	}
+
+	return user, 0, ""
+}
+
+func authHandlers(gctx context.Context, r *gin.Engine, db *bbolt.DB, client *tahvel.Client) {
 	// r.POST("/login", func(c *gin.Context) {
 	r.POST("/login", ginutil.HandlerWithErr(func(c *gin.Context, g *ginutil.Context) (status int, err string) {
-		idCode, phone := c.PostForm("idCode"), c.PostForm("phone")
-		if idCode == "" || phone == "" {
-			return http.StatusBadRequest, "idCode and phone must not be empty"
+		idCode, phone, method := c.PostForm("idCode"), c.PostForm("phone"), c.PostForm("method")
+		if idCode == "" {
+			return http.StatusBadRequest, "idCode must not be empty"
 		}
-		if !strings.HasPrefix(phone, "+") {
-			phone = "+372" + phone
+
+		var auth func(ctx context.Context, authCode chan<- string) (*tahvel.Tahvel, error)
+		switch method {
+		case "sid":
+			auth = func(ctx context.Context, authCode chan<- string) (*tahvel.Tahvel, error) {
+				return client.AuthSid(ctx, idCode, authCode)
+			}
+		default:
+			method = "mid"
+
+			if phone == "" {
+				return http.StatusBadRequest, "idCode and phone must not be empty"
+			}
+			if !strings.HasPrefix(phone, "+") {
+				phone = "+372" + phone
+			}
+
+			auth = func(ctx context.Context, authCode chan<- string) (*tahvel.Tahvel, error) {
+				return client.AuthMid(ctx, idCode, phone, authCode)
+			}
 		}
 
 		ctx, cancel := context.WithTimeout(gctx, time.Minute)
 		authCode := make(chan string, 1)
+		authErr := make(chan error, 1) // before authCode is sent
 		authSession := xid.New().String()
 
+		// before auth can finish
+		authSessionsMu.Lock()
+		AUTHSESSIONS[authSession] = ""
+		authSessionsMu.Unlock()
+
 		go func() {
-			tahvel, err := tahvel.AuthMid(ctx, idCode, phone, authCode)
+			tahvel, err := auth(ctx, authCode)
+
+			authSessionsMu.Lock()
+			defer authSessionsMu.Unlock()
+
 			if err != nil {
 				slog.Info("failed auth", std.SlogErr(err))
 				delete(AUTHSESSIONS, authSession)
+				authErr <- err
 				cancel()
 				return
 			}
@@ -134,13 +328,22 @@ func authHandlers(gctx context.Context, r *gin.Engine) {
 		}()
 
 		select {
+		case err := <-authErr:
+			return upstreamError(c, "logging in", err)
 		case <-ctx.Done():
-			return http.StatusForbidden, ctx.Err().Error()
+			select {
+			case err := <-authErr:
+				return upstreamError(c, "logging in", err)
+			default:
+				return http.StatusForbidden, ctx.Err().Error()
+			}
 		case code := <-authCode:
-			AUTHSESSIONS[authSession] = ""
-
-			c.SetCookie("prefill", strings.Join([]string{idCode, phone}, ","), 60*60*24*15, "", "", !gin.IsDebugging(), true)
-			c.Redirect(http.StatusFound, fmt.Sprintf("/login-wait?authSession=%s&code=%s", authSession, code))
+			if method == "mid" {
+				c.SetCookie("prefill", strings.Join([]string{idCode, phone}, ","), 60*60*24*15, "", "", !gin.IsDebugging(), true)
+			} else {
+				c.SetCookie("prefill", idCode+",", 60*60*24*15, "", "", !gin.IsDebugging(), true)
+			}
+			c.Redirect(http.StatusFound, fmt.Sprintf("/login-wait?authSession=%s&code=%s&method=%s", authSession, code, method))
 			return
 		}
 	}))
@@ -148,15 +351,23 @@ func authHandlers(gctx context.Context, r *gin.Engine) {
 	r.GET("/login-wait", func(c *gin.Context) {
 		authSession, code := c.Query("authSession"), c.Query("code")
 
+		methodName := "Mobiil-ID"
+		if c.Query("method") == "sid" {
+			methodName = "Smart-ID"
+		}
+
+		authSessionsMu.Lock()
 		session, ok := AUTHSESSIONS[authSession]
+		authSessionsMu.Unlock()
 		if !ok {
-			c.HTML(http.StatusForbidden, "error.html", gin.H{"err": "Mobiil-ID-ga sisselogimine ebaõnnestus"})
+			c.HTML(http.StatusForbidden, "error.html", gin.H{"err": methodName + "-ga sisselogimine ebaõnnestus"})
 			return
 		}
 
 		if session == "" {
 			c.HTML(http.StatusAccepted, "login-wait.html", gin.H{
-				"code": code,
+				"code":   code,
+				"method": methodName,
 			})
 			return
 		}
@@ -168,22 +379,28 @@ func authHandlers(gctx context.Context, r *gin.Engine) {
 	})
 
 	r.GET("/logout", ginutil.HandlerWithErr(func(c *gin.Context, g *ginutil.Context) (int, string) {
-		t := tahvel.Tahvel{Session: g.Cookie("session")}
+		t := client.Session(g.Cookie("session"))
 		ctx, cancel := context.WithTimeout(gctx, 10*time.Second)
 		defer cancel()
 
 		c.SetCookie("session", "", -1, "", "", !gin.IsDebugging(), true)
 		c.SetCookie("prefill", "", -1, "", "", !gin.IsDebugging(), true)
 
-		if err := t.Logout(ctx); err != nil {
-			return http.StatusBadGateway, err.Error()
+		// background jobs stop, Tahvel would not accept the session anyway
+		if err := deleteSession(db, t.Session); err != nil {
+			slog.Error("deleting session", std.SlogErr(err))
+		}
+
+		// already logged out, if expired
+		if err := t.Logout(ctx); err != nil && !errors.Is(err, tahvel.ErrSessionExpired) {
+			return upstreamError(c, "logging out", err)
 		}
 
 		return g.Redirect(http.StatusTemporaryRedirect, "/")
 	}))
 }
 
-func mainHandlers(gctx context.Context, r *gin.Engine, db *bbolt.DB) {
+func mainHandlers(gctx context.Context, r *gin.Engine, db *bbolt.DB, client *tahvel.Client) {
 	r.GET("/", func(c *gin.Context) {
 		if authed(c) {
 			c.Redirect(http.StatusTemporaryRedirect, "/search")
@@ -205,24 +422,24 @@ func mainHandlers(gctx context.Context, r *gin.Engine, db *bbolt.DB) {
 		c.HTML(http.StatusOK, "index.html", pageVars)
 	})
 
-	r.GET("/search", searchHandler(gctx, db))
-	r.POST("/search", searchHandler(gctx, db))
+	r.GET("/search", searchHandler(gctx, db, client))
+	r.POST("/search", func(c *gin.Context) {
+		c.Redirect(http.StatusSeeOther, searchURL(c))
+	})
 
 	r.GET("/crowdsource", ginutil.HandlerWithErr(func(c *gin.Context, g *ginutil.Context) (int, string) {
 		if !authed(c) {
 			return g.Redirect(http.StatusTemporaryRedirect, "/")
 		}
 
-		t := tahvel.Tahvel{Session: g.Cookie("session")}
+		t := client.Session(g.Cookie("session"))
 		ctx, cancel := context.WithTimeout(gctx, 5*time.Second)
 		defer cancel()
 
-		user, err := t.GetUser(ctx)
-		if err != nil {
-			c.SetCookie("session", "", -1, "", "", !gin.IsDebugging(), true)
-			return g.Redirect(http.StatusTemporaryRedirect, "/")
+		user, status, errStr := sessionUser(ctx, c, db, t)
+		if user == nil {
+			return status, errStr
 		}
-		c.SetCookie("session", t.Session, user.SessionTimeoutInSeconds, "", "", !gin.IsDebugging(), true)
 
 		//
 
@@ -241,61 +458,147 @@ func mainHandlers(gctx context.Context, r *gin.Engine, db *bbolt.DB) {
 	}))
 }
 
-func searchHandler(gctx context.Context, db *bbolt.DB) gin.HandlerFunc {
+func searchHandler(gctx context.Context, db *bbolt.DB, client *tahvel.Client) gin.HandlerFunc {
 	return ginutil.HandlerWithErr(func(c *gin.Context, g *ginutil.Context) (int, string) {
 		if !authed(c) {
 			return g.Redirect(http.StatusTemporaryRedirect, "/")
 		}
 
-		t := tahvel.Tahvel{Session: g.Cookie("session")}
+		t := client.Session(g.Cookie("session"))
 		ctx, cancel := context.WithTimeout(gctx, 20*time.Second)
 		defer cancel()
 
-		user, err := t.GetUser(ctx)
-		if err != nil {
-			c.SetCookie("session", "", -1, "", "", !gin.IsDebugging(), true)
-			return g.Redirect(http.StatusTemporaryRedirect, "/")
+		user, status, errStr := sessionUser(ctx, c, db, t)
+		if user == nil {
+			return status, errStr
 		}
-		c.SetCookie("session", t.Session, user.SessionTimeoutInSeconds, "", "", !gin.IsDebugging(), true)
 
 		//
-		now := time.Now().In(TIMEZONE)
+		now := client.Now()
 
 		bookings, err := t.Bookings(ctx, now)
 		if err != nil {
-			return http.StatusBadGateway, "listing bookings: " + err.Error()
+			return upstreamError(c, "listing bookings", err)
+		}
+
+		var recurringFailures []string
+		if rules, err := userRecurringRules(db, user.UserId); err == nil {
+			for _, rule := range rules {
+				recurringFailures = append(recurringFailures, rule.Failures(now.Format("2006-01-02"))...)
+			}
+		}
+
+		presets, err := userPresets(db, user.UserId)
+		if err != nil {
+			slog.Warn("listing presets", std.SlogErr(err))
+		}
+
+		waitlist, err := userWaitlist(db, user.UserId)
+		if err != nil {
+			slog.Warn("listing waitlist", std.SlogErr(err))
 		}
 
 		pageVars := gin.H{
-			"unknownACL": user.UnknownACLs(),
+			"settings":          getSettings(db, user.UserId),
+			"smtp":              SMTP_ADDR != "",
+			"unknownACL":        user.UnknownACLs(),
+			"recurringFailures": recurringFailures,
+			"presets":           presets,
+			"waitlist":          waitlist,
 
 			"bookings": bookings,
+			"move":     c.Query("move"),
+			"weekdays": map[string]bool{},
 
 			"today":   now.Format("2006-01-02"),
+			"nowTime": now.Format("15:04"),
+			"maxDate": now.AddDate(0, 0, client.BookingHorizon()).Format("2006-01-02"),
 			"now":     now.Round(5 * time.Minute).Format("15:04"),
-			"nowplus": now.Round(5 * time.Minute).Add(45 * time.Minute).Format("15:04"),
+			"nowplus": now.Round(5 * time.Minute).Add(QUICKBOOK_LENGTH).Format("15:04"),
+
+			"quickbookLength": int(QUICKBOOK_LENGTH.Minutes()),
 		}
 
-		date, err := time.Parse("2006-01-02", c.PostForm("date"))
+		equipment, err := client.GetEquipment(ctx)
 		if err != nil {
+			return upstreamError(c, "listing equipment", err)
+		}
+
+		needs := searchNeeds(c.Request.URL.Query())
+		maps.Copy(pageVars, gin.H{
+			"needs":            needs,
+			"equipmentAll":     len(needs.AllOf) != 0,
+			"equipmentOptions": equipmentOptions(equipment, append(needs.OneOf, needs.AllOf...)),
+		})
+
+		favIds, err := favourites(db, user.UserId)
+		if err != nil {
+			slog.Warn("listing favourites", std.SlogErr(err))
+		}
+
+		date, err := client.Date(c.Query("date"))
+		if err != nil {
+			if len(favIds) != 0 {
+				rooms, err := t.GetRooms(ctx, now)
+				if err != nil {
+					return upstreamError(c, "listing rooms", err)
+				}
+				pageVars["favouriteRooms"] = favouriteRooms(favIds, rooms, tahvel.Interval{})
+				pageVars["favouriteDate"] = now.Format("2006-01-02")
+			}
+
 			return g.HTML(http.StatusFound, "search.html", pageVars)
 		}
 
-		rooms, err := t.GetRooms(ctx, date)
+		want, err := searchInterval(date, c.Query("startTime"), c.Query("stopTime"))
 		if err != nil {
-			return http.StatusBadGateway, "listing rooms: " + err.Error()
+			return http.StatusBadRequest, "Vigane algus- või lõpuaeg"
+		}
+
+		maps.Copy(pageVars, gin.H{
+			"bookDate":  date.Format("2006-01-02"),
+			"bookStart": c.Query("startTime"),
+			"bookStop":  c.Query("stopTime"),
+			"length":    c.Query("length"),
+			"sort":      c.Query("sort"),
+
+			"searchQuery": c.Request.URL.Query().Encode(),
+		})
+
+		if c.Query("until") != "" {
+			return searchDays(ctx, c, g, db, t, user, pageVars, date, needs)
 		}
 
-		fuzzy := time.Minute // TODO: test
-		rooms, conflicting, dicks := tahvel.FilterRooms(db, rooms, user.Roles,
-			c.PostForm("needsPiano") == "needsPiano",
-			c.PostForm("startTime"), c.PostForm("stopTime"), fuzzy,
-		)
+		rooms, err := t.GetRooms(ctx, date)
+		if err != nil {
+			return upstreamError(c, "listing rooms", err)
+		}
+		pageVars["favouriteRooms"] = favouriteRooms(favIds, rooms, want)
+		pageVars["favouriteDate"] = date.Format("2006-01-02")
 
-		equipment, err := tahvel.GetEquipment(ctx)
+		length, err := searchLength(c.Query("length"))
 		if err != nil {
-			return http.StatusBadGateway, "listing equipment: " + err.Error()
+			return http.StatusBadRequest, "Vigane kestus"
 		}
+
+		if length != 0 {
+			slots := tahvel.FindSlots(db, rooms, user.Roles, needs, slotWindow(want, now), length)
+			if len(slots) > MAX_SLOTS {
+				slots = slots[:MAX_SLOTS]
+			}
+
+			maps.Copy(pageVars, gin.H{
+				"slots":        slots,
+				"waitlistable": len(slots) == 0,
+			})
+
+			return g.HTML(http.StatusFound, "search.html", pageVars)
+		}
+
+		rooms, conflicting, dicks := tahvel.FilterRooms(db, rooms, user.Roles, needs, want, OVERLAP_TOLERANCE)
+		tahvel.Rank(rooms, want, needs, favouriteSet(db, user.UserId))
+		sortRooms(rooms, c.Query("sort"))
+
 		// equipment = tahvel.FilterEquipmentReferenced(equipment, rooms)
 
 		var hasCrowdsource bool
@@ -316,10 +619,8 @@ func searchHandler(gctx context.Context, db *bbolt.DB) gin.HandlerFunc {
 			"hasCrowdsource": hasCrowdsource,
 			"rooms":          rooms,
 
-			"bookStart": c.PostForm("startTime"),
-			"bookStop":  c.PostForm("stopTime"),
-
-			"conflicting": conflicting,
+			"conflicting":  conflicting,
+			"waitlistable": len(rooms) == 0 && c.Query("stopTime") != "",
 
 			"dicks": dicks,
 		})
@@ -328,23 +629,313 @@ func searchHandler(gctx context.Context, db *bbolt.DB) gin.HandlerFunc {
 	})
 }
 
-func bookingHandlers(gctx context.Context, r *gin.Engine) {
+// searchURL is the canonical GET URL of a submitted search form,
+// without empty fields.
+func searchURL(c *gin.Context) string {
+	_ = c.Request.ParseForm()
+
+	query := make(url.Values)
+	for k, vs := range c.Request.PostForm {
+		for _, v := range vs {
+			if v != "" {
+				query.Add(k, v)
+			}
+		}
+	}
+
+	return "/search?" + query.Encode()
+}
+
+type searchDay struct {
+	Date    string
+	Weekday string
+	Rooms   []tahvel.Room
+	Slots   []tahvel.Slot
+}
+
+// searchDays searches each matching day from date to the until form value.
+func searchDays(ctx context.Context, c *gin.Context, g *ginutil.Context,
+	db *bbolt.DB, t *tahvel.Tahvel, user *tahvel.User, pageVars gin.H, from time.Time, needs tahvel.Needs,
+) (int, string) {
+	until, err := t.Date(c.Query("until"))
+	if err != nil || until.Before(from) {
+		return http.StatusBadRequest, "Vigane lõppkuupäev"
+	}
+	now := t.Now()
+	if horizon := tahvel.Day(now).Start.AddDate(0, 0, t.BookingHorizon()); until.After(horizon) {
+		until = horizon
+	}
+
+	var weekdays []time.Weekday
+	weekdaySet := make(map[string]bool) // for the form
+	for _, dS := range c.QueryArray("weekday") {
+		d, err := strconv.Atoi(dS)
+		if err != nil || d < 0 || d > 6 {
+			return http.StatusBadRequest, "Vigane nädalapäev"
+		}
+		weekdays = append(weekdays, time.Weekday(d))
+		weekdaySet[dS] = true
+	}
+
+	var dates []time.Time
+	for day := from; !day.After(until); day = day.AddDate(0, 0, 1) {
+		if len(weekdays) == 0 || slices.Contains(weekdays, day.Weekday()) {
+			dates = append(dates, day)
+		}
+	}
+
+	length, err := searchLength(c.Query("length"))
+	if err != nil {
+		return http.StatusBadRequest, "Vigane kestus"
+	}
+
+	roomsOn, err := t.RoomsOn(ctx, dates)
+	if err != nil {
+		return upstreamError(c, "listing rooms", err)
+	}
+
+	days := make([]searchDay, 0, len(dates))
+	for i, date := range dates {
+		want, err := searchInterval(date, c.Query("startTime"), c.Query("stopTime"))
+		if err != nil {
+			return http.StatusBadRequest, "Vigane algus- või lõpuaeg"
+		}
+
+		day := searchDay{Date: date.Format("2006-01-02"), Weekday: weekdayNames[date.Weekday()]}
+
+		if length != 0 {
+			day.Slots = tahvel.FindSlots(db, roomsOn[i], user.Roles, needs, slotWindow(want, now), length)
+			if len(day.Slots) > MAX_SLOTS {
+				day.Slots = day.Slots[:MAX_SLOTS]
+			}
+		} else {
+			day.Rooms, _, _ = tahvel.FilterRooms(db, roomsOn[i], user.Roles, needs, want, OVERLAP_TOLERANCE)
+			tahvel.Rank(day.Rooms, want, needs, favouriteSet(db, user.UserId))
+			sortRooms(day.Rooms, c.Query("sort"))
+		}
+
+		days = append(days, day)
+	}
+
+	maps.Copy(pageVars, gin.H{
+		"days":     days,
+		"until":    until.Format("2006-01-02"),
+		"weekdays": weekdaySet,
+	})
+
+	return g.HTML(http.StatusFound, "search.html", pageVars)
+}
+
+// sortRooms by a column of the results, by is empty for Rank order.
+func sortRooms(rooms []tahvel.Room, by string) {
+	switch by {
+	case "code":
+		slices.SortStableFunc(rooms, func(a, b tahvel.Room) int { return strings.Compare(a.RoomCode, b.RoomCode) })
+	case "places":
+		slices.SortStableFunc(rooms, func(a, b tahvel.Room) int { return a.Places - b.Places })
+	case "piano":
+		slices.SortStableFunc(rooms, func(a, b tahvel.Room) int { return b.PianoCount - a.PianoCount })
+	case "free":
+		slices.SortStableFunc(rooms, func(a, b tahvel.Room) int { return int(b.FreeAfter - a.FreeAfter) })
+	}
+}
+
+// searchNeeds reads the room filters from form.
+func searchNeeds(form url.Values) tahvel.Needs {
+	needs := tahvel.Needs{
+		Piano:    form.Get("needsPiano") == "needsPiano",
+		Building: strings.TrimSpace(form.Get("building")),
+		Text:     strings.TrimSpace(form.Get("q")),
+	}
+
+	if equipment := form["equipment"]; form.Get("equipmentMode") == "all" {
+		needs.AllOf = equipment
+	} else {
+		needs.OneOf = equipment
+	}
+
+	needs.MinPlaces, _ = strconv.Atoi(form.Get("minPlaces"))
+
+	return needs
+}
+
+type equipmentOption struct {
+	Code, Name string
+	Checked    bool
+}
+
+// equipmentOptions lists equipment for the search form, by name.
+func equipmentOptions(equipment map[string]string, checked []string) (options []equipmentOption) {
+	for code, name := range equipment {
+		options = append(options, equipmentOption{
+			Code:    code,
+			Name:    strings.TrimPrefix(name, "_"),
+			Checked: slices.Contains(checked, code),
+		})
+	}
+
+	slices.SortFunc(options, func(a, b equipmentOption) int { return strings.Compare(a.Name, b.Name) })
+
+	return
+}
+
+// searchLength is the optional length form value in minutes.
+func searchLength(minutesS string) (time.Duration, error) {
+	if minutesS == "" {
+		return 0, nil
+	}
+
+	minutes, err := strconv.Atoi(minutesS)
+	if err != nil || minutes <= 0 {
+		return 0, fmt.Errorf("invalid length %q", minutesS)
+	}
+
+	return time.Duration(minutes) * time.Minute, nil
+}
+
+// slotWindow is want until the end of its day, not before now.
+func slotWindow(want tahvel.Interval, now time.Time) tahvel.Interval {
+	if want.End.IsZero() {
+		want.End = tahvel.Day(want.Start).End
+	}
+	if soonest := now.Truncate(5 * time.Minute).Add(5 * time.Minute); want.Start.Before(soonest) {
+		want.Start = soonest
+	}
+
+	return want
+}
+
+// searchInterval is start to stop on date, either may be empty.
+// Without start, from the beginning of date. Without stop, open-ended.
+func searchInterval(date time.Time, start, stop string) (tahvel.Interval, error) {
+	if start == "" {
+		start = "00:00"
+	}
+	if stop == "" {
+		startT, err := tahvel.At(date, start)
+		return tahvel.Interval{Start: startT}, err
+	}
+
+	return tahvel.ParseTimes(date, start+" - "+stop)
+}
+
+// bookLink is a link to book a room, or while moving a booking (?move=), to move it there.
+type bookLink struct {
+	Move, Search      string
+	Room              int
+	Date, Start, Stop string
+	Label             string // after "Broneeri" or "Liiguta", eg. the time
+}
+
+// newBookLink is for booklink.html, page is the search page's vars.
+func newBookLink(page gin.H, room int, date, start, stop, label string) bookLink {
+	move, _ := page["move"].(string)
+	search, _ := page["searchQuery"].(string)
+
+	return bookLink{
+		Move: move, Search: search,
+		Room: room,
+		Date: date, Start: start, Stop: stop,
+		Label: label,
+	}
+}
+
+// bookingQuery parses rooms (roomKey), and start and stop (15:04) on date (2006-01-02, default today)
+// of /book and /booking/move, crossing midnight if stop is before start.
+// If errStr is set, respond with 400.
+func bookingQuery(c *gin.Context, client *tahvel.Client, roomKey string) (ids []int, iv tahvel.Interval, errStr string) {
+	dateS := c.Query("date")
+	if dateS == "" {
+		dateS = client.Now().Format("2006-01-02")
+	}
+	date, err := client.Date(dateS)
+	if err != nil {
+		return nil, iv, "Vigane kuupäev"
+	}
+
+	start, stop := c.Query("start"), c.Query("stop")
+	if start == stop {
+		return nil, iv, "Algus- ja lõpuaeg on samad"
+	}
+	iv, err = tahvel.ParseTimes(date, start+" - "+stop)
+	if err != nil {
+		return nil, iv, "Vigane algus- või lõpuaeg"
+	}
+
+	for _, idS := range c.QueryArray(roomKey) {
+		id, err := strconv.Atoi(idS)
+		if err != nil {
+			return nil, iv, "Vigane ruum"
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, iv, ""
+}
+
+func bookingHandlers(gctx context.Context, r *gin.Engine, db *bbolt.DB, client *tahvel.Client) {
 	r.GET("/book", ginutil.HandlerWithErr(func(c *gin.Context, g *ginutil.Context) (int, string) {
 		if !authed(c) {
 			return g.Redirect(http.StatusTemporaryRedirect, "/")
 		}
 
-		t := tahvel.Tahvel{Session: g.Cookie("session")}
-		ctx, cancel := context.WithTimeout(gctx, 5*time.Second)
+		t := client.Session(g.Cookie("session"))
+		ctx, cancel := context.WithTimeout(gctx, 20*time.Second)
+		defer cancel()
+
+		ids, iv, errStr := bookingQuery(c, client, "id")
+		if errStr != "" {
+			return http.StatusBadRequest, errStr
+		}
+		if len(ids) == 0 {
+			return http.StatusBadRequest, "Vali vähemalt üks ruum"
+		}
+
+		if err := client.CheckHorizon(iv.Start); err != nil {
+			return horizonError(client)
+		}
+
+		if err := t.CreateBooking(ctx, ids, iv); err != nil {
+			if errors.Is(err, tahvel.ErrOccupied) && len(ids) == 1 {
+				return bookFallback(ctx, c, g, db, t, ids[0], iv, err)
+			}
+			return upstreamError(c, "creating booking", err)
+		}
+
+		return g.Redirect(http.StatusTemporaryRedirect, "/")
+	}))
+
+	r.GET("/book/now", ginutil.HandlerWithErr(func(c *gin.Context, g *ginutil.Context) (int, string) {
+		if !authed(c) {
+			return g.Redirect(http.StatusTemporaryRedirect, "/")
+		}
+
+		t := client.Session(g.Cookie("session"))
+		ctx, cancel := context.WithTimeout(gctx, 20*time.Second)
 		defer cancel()
 
-		todayDateS := time.Now().In(TIMEZONE).Format("2006-01-02")
-		startT, _ := time.Parse("2006-01-02 15:04", todayDateS+" "+c.Query("start"))
-		stopT, _ := time.Parse("2006-01-02 15:04", todayDateS+" "+c.Query("stop"))
-		id, _ := strconv.Atoi(c.Query("id"))
+		user, status, errStr := sessionUser(ctx, c, db, t)
+		if user == nil {
+			return status, errStr
+		}
+
+		now := client.Now()
+		start := now.Round(5 * time.Minute)
+		query := url.Values{
+			"startTime": {start.Format("15:04")},
+			"stopTime":  {start.Add(QUICKBOOK_LENGTH).Format("15:04")},
+		}
 
-		if err := t.CreateBooking(ctx, id, startT, stopT); err != nil {
-			return http.StatusBadGateway, err.Error()
+		candidates, err := searchCandidates(ctx, db, t, user, query, tahvel.Day(now).Start)
+		if err == nil {
+			_, err = bookFirst(ctx, t, candidates)
+		}
+		if err != nil {
+			var bad badSearch
+			if errors.As(err, &bad) {
+				return http.StatusUnprocessableEntity, bad.Error()
+			}
+			return upstreamError(c, "booking now", err)
 		}
 
 		return g.Redirect(http.StatusTemporaryRedirect, "/")
@@ -355,14 +946,165 @@ func bookingHandlers(gctx context.Context, r *gin.Engine) {
 			return g.Redirect(http.StatusTemporaryRedirect, "/")
 		}
 
-		t := tahvel.Tahvel{Session: g.Cookie("session")}
+		t := client.Session(g.Cookie("session"))
 		ctx, cancel := context.WithTimeout(gctx, 5*time.Second)
 		defer cancel()
 
-		if err := t.CancelBooking(ctx, c.Query("id")); err != nil {
-			return http.StatusBadGateway, err.Error()
+		id, err := strconv.Atoi(c.Query("id"))
+		if err != nil {
+			return http.StatusBadRequest, "Vigane broneering"
+		}
+		version, _ := strconv.Atoi(c.Query("version"))
+
+		if err := t.CancelBooking(ctx, id, version); err != nil {
+			return upstreamError(c, "cancelling booking", err)
+		}
+
+		return g.Redirect(http.StatusTemporaryRedirect, "/")
+	}))
+
+	r.GET("/booking/extend", ginutil.HandlerWithErr(func(c *gin.Context, g *ginutil.Context) (int, string) {
+		if !authed(c) {
+			return g.Redirect(http.StatusTemporaryRedirect, "/")
+		}
+
+		t := client.Session(g.Cookie("session"))
+		ctx, cancel := context.WithTimeout(gctx, 10*time.Second)
+		defer cancel()
+
+		booking, status, errStr := findBooking(ctx, c, t, c.Query("id"))
+		if booking == nil {
+			return status, errStr
+		}
+
+		minutes, err := strconv.Atoi(c.DefaultQuery("minutes", "30"))
+		if err != nil || minutes <= 0 {
+			return http.StatusBadRequest, "Vigane pikendus"
+		}
+
+		if err := t.ExtendBooking(ctx, *booking, time.Duration(minutes)*time.Minute); err != nil {
+			return upstreamError(c, "extending booking", err)
+		}
+
+		return g.Redirect(http.StatusTemporaryRedirect, "/")
+	}))
+
+	r.GET("/booking/end", ginutil.HandlerWithErr(func(c *gin.Context, g *ginutil.Context) (int, string) {
+		if !authed(c) {
+			return g.Redirect(http.StatusTemporaryRedirect, "/")
+		}
+
+		t := client.Session(g.Cookie("session"))
+		ctx, cancel := context.WithTimeout(gctx, 10*time.Second)
+		defer cancel()
+
+		booking, status, errStr := findBooking(ctx, c, t, c.Query("id"))
+		if booking == nil {
+			return status, errStr
+		}
+
+		if err := t.EndBooking(ctx, *booking); err != nil {
+			return upstreamError(c, "ending booking", err)
+		}
+
+		return g.Redirect(http.StatusTemporaryRedirect, "/")
+	}))
+
+	r.GET("/booking/move", ginutil.HandlerWithErr(func(c *gin.Context, g *ginutil.Context) (int, string) {
+		if !authed(c) {
+			return g.Redirect(http.StatusTemporaryRedirect, "/")
+		}
+
+		t := client.Session(g.Cookie("session"))
+		ctx, cancel := context.WithTimeout(gctx, 20*time.Second)
+		defer cancel()
+
+		booking, status, errStr := findBooking(ctx, c, t, c.Query("id"))
+		if booking == nil {
+			return status, errStr
+		}
+
+		ids, iv, errStr := bookingQuery(c, client, "room")
+		if errStr != "" {
+			return http.StatusBadRequest, errStr
+		}
+		if len(ids) == 0 {
+			ids = booking.RoomIds()
+		}
+
+		if err := client.CheckHorizon(iv.Start); err != nil {
+			return horizonError(client)
+		}
+
+		if err := t.MoveBooking(ctx, *booking, ids, iv); err != nil {
+			if errors.Is(err, tahvel.ErrMovedNotCancelled) {
+				slog.Warn("upstream error", slog.String("doing", "moving booking"), std.SlogErr(err))
+				return http.StatusBadGateway, "Uus aeg on broneeritud, aga vana broneeringu tühistamine ebaõnnestus. Tühista see käsitsi."
+			}
+			return upstreamError(c, "moving booking", err)
 		}
 
 		return g.Redirect(http.StatusTemporaryRedirect, "/")
 	}))
 }
+
+// how many alternatives bookFallback offers
+const FALLBACK_OFFERS = 3
+
+// bookFallback offers, or with AutoFallback books, the next best room,
+// after the chosen room was taken meanwhile (occupied).
+func bookFallback(ctx context.Context, c *gin.Context, g *ginutil.Context,
+	db *bbolt.DB, t *tahvel.Tahvel, failed int, iv tahvel.Interval, occupied error,
+) (int, string) {
+	user, status, errStr := sessionUser(ctx, c, db, t)
+	if user == nil {
+		return status, errStr
+	}
+
+	candidates, err := fallbackCandidates(ctx, db, t, user, c.Query("search"), failed, iv)
+	if err != nil {
+		slog.Warn("finding fallback rooms", std.SlogErr(err))
+	}
+	if len(candidates) == 0 {
+		return upstreamError(c, "creating booking", occupied)
+	}
+
+	settings := getSettings(db, user.UserId)
+	pageVars := gin.H{
+		"date":         iv.Start.Format("2006-01-02"),
+		"time":         iv.String(),
+		"autoFallback": settings.AutoFallback,
+		"search":       c.Query("search"),
+	}
+
+	if settings.AutoFallback {
+		booked, err := bookFirst(ctx, t, candidates)
+		if err != nil {
+			return upstreamError(c, "booking fallback", err)
+		}
+
+		pageVars["booked"] = booked
+		return g.HTML(http.StatusOK, "fallback.html", pageVars)
+	}
+
+	pageVars["offers"] = candidates[:min(len(candidates), FALLBACK_OFFERS)]
+	return g.HTML(http.StatusConflict, "fallback.html", pageVars)
+}
+
+// findBooking looks up one of the user's upcoming bookings.
+// If booking is nil, return status and errStr.
+func findBooking(ctx context.Context, c *gin.Context, t *tahvel.Tahvel, id string) (booking *tahvel.Booking, status int, errStr string) {
+	bookings, err := t.Bookings(ctx, t.Now())
+	if err != nil {
+		status, errStr = upstreamError(c, "listing bookings", err)
+		return nil, status, errStr
+	}
+
+	for _, b := range bookings {
+		if strconv.Itoa(b.Id) == id {
+			return &b, 0, ""
+		}
+	}
+
+	return nil, http.StatusNotFound, "Broneeringut ei leitud"
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jtagcat/teinetahvel/tahvel"
+	"github.com/jtagcat/teinetahvel/tahveltest"
+	bb "github.com/jtagcat/util/bbolt"
+	"go.etcd.io/bbolt"
+)
+
+// Seed's Mobile-ID user
+const (
+	testIDCode = "60001019906"
+	testPhone  = "00000766"
+	testUserId = 1001
+)
+
+type testApp struct {
+	t      *testing.T
+	fake   *tahveltest.Server
+	db     *bbolt.DB
+	client *tahvel.Client
+	router *gin.Engine
+
+	session string
+	date    string // clear of Seed's events, within the booking horizon
+}
+
+func newTestApp(t *testing.T) *testApp {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	tahvel.BOOKINGNAME = "teinetahvel test"
+
+	fake := tahveltest.NewServer()
+	t.Cleanup(fake.Close)
+	fake.Seed()
+
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "teinetahvel.db"), 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := createBuckets(db); err != nil {
+		t.Fatal(err)
+	}
+
+	client := fake.TahvelClient()
+
+	return &testApp{
+		t:      t,
+		fake:   fake,
+		db:     db,
+		client: client,
+		router: newRouter(t.Context(), db, client),
+		date:   client.Now().AddDate(0, 0, 2).Format("2006-01-02"),
+	}
+}
+
+// login skips Mobile-ID, see TestLogin for the full flow.
+func (a *testApp) login() {
+	a.t.Helper()
+
+	session, err := a.fake.Login(testIDCode)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	a.session = session
+}
+
+// at is wall clock on a.date, as the fake stores it.
+func (a *testApp) at(clock string) time.Time {
+	a.t.Helper()
+
+	at, err := time.Parse("2006-01-02 15:04", a.date+" "+clock)
+	if err != nil {
+		a.t.Fatal(err)
+	}
+
+	return at
+}
+
+func (a *testApp) do(req *http.Request) *httptest.ResponseRecorder {
+	if a.session != "" {
+		req.AddCookie(&http.Cookie{Name: "session", Value: a.session})
+	}
+
+	w := httptest.NewRecorder()
+	a.router.ServeHTTP(w, req)
+
+	return w
+}
+
+func (a *testApp) get(path string) *httptest.ResponseRecorder {
+	return a.do(httptest.NewRequest(http.MethodGet, path, nil))
+}
+
+func (a *testApp) post(path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return a.do(req)
+}
+
+// ownEvents are the test user's bookings in the fake.
+func (a *testApp) ownEvents() []tahveltest.Event {
+	return slices.DeleteFunc(a.fake.Events(), func(e tahveltest.Event) bool { return e.Owner != testIDCode })
+}
+
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+
+	if w.Code != status {
+		t.Fatalf("status %d, want %d; body:\n%s", w.Code, status, w.Body.String())
+	}
+}
+
+func expectRedirect(t *testing.T, w *httptest.ResponseRecorder, location string) {
+	t.Helper()
+
+	expectStatus(t, w, http.StatusTemporaryRedirect)
+	if got := w.Header().Get("Location"); got != location {
+		t.Fatalf("redirected to %q, want %q", got, location)
+	}
+}
+
+//
+
+func TestLogin(t *testing.T) {
+	a := newTestApp(t)
+
+	w := a.post("/login", url.Values{"idCode": {testIDCode}, "phone": {testPhone}})
+	expectStatus(t, w, http.StatusFound)
+	wait := w.Header().Get("Location")
+	if !strings.HasPrefix(wait, "/login-wait?") {
+		t.Fatalf("redirected to %q, want /login-wait", wait)
+	}
+
+	// the fake confirms without AuthDelay, but in the background
+	for range 50 {
+		time.Sleep(20 * time.Millisecond)
+
+		w = a.get(wait)
+		if w.Code != http.StatusAccepted {
+			break
+		}
+	}
+	expectRedirect(t, w, "/search")
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "session" {
+			a.session = cookie.Value
+		}
+	}
+	if a.session == "" {
+		t.Fatal("no session cookie set")
+	}
+
+	expectStatus(t, a.get("/search"), http.StatusFound)
+}
+
+func TestLoginRefused(t *testing.T) {
+	a := newTestApp(t)
+
+	for _, tc := range []struct {
+		form    url.Values
+		message string
+	}{
+		{url.Values{"idCode": {testIDCode}, "phone": {"55555555"}}, "notMidClient"},
+		{url.Values{"idCode": {"39999999999"}, "method": {"sid"}}, "userAccountNotFound"},
+	} {
+		w := a.post("/login", tc.form)
+		expectStatus(t, w, http.StatusUnprocessableEntity)
+
+		if body := w.Body.String(); !strings.Contains(body, tc.message) {
+			t.Errorf("Tahvel's %s not shown: %s", tc.message, body)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	a.fake.AddEvent(tahveltest.Event{Name: "Tund", Start: a.at("10:00"), End: a.at("11:30"), Rooms: []int{1}})
+
+	w := a.get("/search?date=" + a.date + "&startTime=10:00&stopTime=11:00&needsPiano=needsPiano")
+	expectStatus(t, w, http.StatusFound)
+
+	body := w.Body.String()
+	if !strings.Contains(body, "/book?id=2&date="+a.date+"&start=10%3a00") {
+		t.Error("free D108 is not bookable")
+	}
+	if strings.Contains(body, "/book?id=1&date="+a.date+"&start=10%3a00") {
+		t.Error("busy D107 is bookable")
+	}
+	if !strings.Contains(body, "/book?id=1&date="+a.date+"&start=11%3a30") {
+		t.Error("D107 is not offered from when it frees up")
+	}
+}
+
+func TestBook(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+
+	expectRedirect(t, a.get("/book?id=2&date="+a.date+"&start=10:00&stop=11:00"), "/")
+
+	events := a.ownEvents()
+	if len(events) != 1 {
+		t.Fatalf("%d bookings, want 1", len(events))
+	}
+	if e := events[0]; !slices.Equal(e.Rooms, []int{2}) || !e.Start.Equal(a.at("10:00")) || !e.End.Equal(a.at("11:00")) {
+		t.Errorf("booked %v %s - %s", e.Rooms, e.Start, e.End)
+	}
+}
+
+func TestBookOccupied(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	a.fake.AddEvent(tahveltest.Event{Name: "Tund", Start: a.at("10:00"), End: a.at("11:30"), Rooms: []int{1}})
+
+	w := a.get("/book?id=1&date=" + a.date + "&start=10:00&stop=11:00")
+	expectStatus(t, w, http.StatusConflict)
+
+	if n := len(a.ownEvents()); n != 0 {
+		t.Fatalf("%d bookings, want 0", n)
+	}
+	// offered instead
+	if !strings.Contains(w.Body.String(), "/book?id=2&") {
+		t.Error("free D108 is not offered")
+	}
+}
+
+func TestBookUnknownRoom(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+
+	w := a.get("/book?id=9999&date=" + a.date + "&start=10:00&stop=11:00")
+	expectStatus(t, w, http.StatusUnprocessableEntity)
+
+	if n := len(a.ownEvents()); n != 0 {
+		t.Fatalf("%d bookings, want 0", n)
+	}
+	if strings.Contains(w.Body.String(), "/book?id=") {
+		t.Error("other rooms offered for a room that does not exist")
+	}
+}
+
+func TestBookOccupiedKeepsSearch(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	a.fake.AddEvent(tahveltest.Event{Name: "Tund", Start: a.at("10:00"), End: a.at("11:30"), Rooms: []int{1}})
+
+	search := url.Values{"date": {a.date}, "startTime": {"10:00"}, "stopTime": {"11:00"}, "building": {"B"}}
+
+	// links on the results page carry the search, templates escape in lowercase
+	body := a.get("/search?" + search.Encode()).Body.String()
+	if !strings.Contains(strings.ToLower(body), strings.ToLower("&search="+url.QueryEscape(search.Encode()))) {
+		t.Error("book links do not carry the search")
+	}
+
+	// no Referer
+	w := a.get("/book?id=1&date=" + a.date + "&start=10:00&stop=11:00&search=" + url.QueryEscape(search.Encode()))
+	expectStatus(t, w, http.StatusConflict)
+
+	body = w.Body.String()
+	if !strings.Contains(body, "/book?id=4&") {
+		t.Error("B104 in the searched building is not offered")
+	}
+	if strings.Contains(body, "/book?id=2&") {
+		t.Error("D108 outside the searched building is offered")
+	}
+}
+
+func TestCancel(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+
+	expectRedirect(t, a.get("/book?id=2&date="+a.date+"&start=10:00&stop=11:00"), "/")
+	events := a.ownEvents()
+	if len(events) != 1 {
+		t.Fatalf("%d bookings, want 1", len(events))
+	}
+
+	expectRedirect(t, a.get("/cancel?id="+strconv.Itoa(events[0].Id)+"&version="+strconv.Itoa(events[0].Version)), "/")
+
+	if n := len(a.ownEvents()); n != 0 {
+		t.Fatalf("%d bookings after cancelling, want 0", n)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+
+	expectRedirect(t, a.get("/logout"), "/")
+
+	_, err := a.client.Session(a.session).GetUser(context.Background())
+	if !errors.Is(err, tahvel.ErrSessionExpired) {
+		t.Fatalf("session still valid after logout: %v", err)
+	}
+}
+
+// storedSession is the session remembered for the test user's background jobs.
+func (a *testApp) storedSession() string {
+	return bb.Get(a.db, []byte("sessions"), strconv.Itoa(testUserId))
+}
+
+func TestSessionStored(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+
+	expectStatus(t, a.get("/search"), http.StatusFound)
+	if a.storedSession() != "" {
+		t.Fatal("session stored without background jobs")
+	}
+
+	rule := url.Values{"weekday": {"1"}, "startTime": {"16:00"}, "stopTime": {"18:00"}, "until": {a.date}, "rooms": {"D108"}}
+	for range 2 {
+		expectStatus(t, a.post("/recurring", rule), http.StatusFound)
+	}
+	if a.storedSession() != a.session {
+		t.Fatal("session not stored for recurring bookings")
+	}
+
+	rules, err := recurringRules(a.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectRedirect(t, a.get("/recurring/delete?id="+rules[0].Id), "/recurring")
+	if a.storedSession() == "" {
+		t.Fatal("session forgotten with a rule left")
+	}
+	expectRedirect(t, a.get("/recurring/delete?id="+rules[1].Id), "/recurring")
+	if a.storedSession() != "" {
+		t.Fatal("session kept after deleting the last rule")
+	}
+
+	expectStatus(t, a.post("/recurring", rule), http.StatusFound)
+	expectRedirect(t, a.get("/logout"), "/")
+	if a.storedSession() != "" {
+		t.Fatal("session kept after logging out")
+	}
+
+	// the rule continues with the new session
+	a.login()
+	expectStatus(t, a.get("/search"), http.StatusFound)
+	if a.storedSession() != a.session {
+		t.Fatal("session not stored after logging in again")
+	}
+}
+
+func TestSessionExpired(t *testing.T) {
+	a := newTestApp(t)
+	a.session = "expired"
+
+	w := a.get("/search")
+	expectRedirect(t, w, "/")
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "session" && cookie.MaxAge < 0 {
+			return
+		}
+	}
+	t.Fatal("expired session cookie not cleared")
+}
+
+func TestSessionKeptOnOutage(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+	a.fake.Close()
+
+	w := a.get("/search")
+	expectStatus(t, w, http.StatusBadGateway)
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "session" && cookie.MaxAge < 0 {
+			t.Fatal("logged out while Tahvel is down")
+		}
+	}
+}
+
+func TestBookBeyondHorizon(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+
+	date := a.client.Now().AddDate(0, 0, a.client.BookingHorizon()+1).Format("2006-01-02")
+	w := a.get("/book?id=2&date=" + date + "&start=10:00&stop=11:00")
+	expectStatus(t, w, http.StatusBadRequest)
+
+	if !strings.Contains(w.Body.String(), "Broneerida saab tänasest kuni "+strconv.Itoa(a.client.BookingHorizon())+" päeva ette.") {
+		t.Errorf("horizon not explained: %s", w.Body.String())
+	}
+}
+
+func TestBookPastMidnight(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+
+	expectRedirect(t, a.get("/book?id=2&date="+a.date+"&start=23:00&stop=01:00"), "/")
+
+	events := a.ownEvents()
+	if len(events) != 1 {
+		t.Fatalf("%d bookings, want 1", len(events))
+	}
+	if e := events[0]; !e.Start.Equal(a.at("23:00")) || !e.End.Equal(a.at("01:00").AddDate(0, 0, 1)) {
+		t.Errorf("booked %s - %s", e.Start, e.End)
+	}
+}
+
+func TestSearchDaysMove(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+
+	until := a.at("00:00").AddDate(0, 0, 1).Format("2006-01-02")
+	for _, length := range []string{"", "&length=30"} {
+		w := a.get("/search?move=42&date=" + a.date + "&until=" + until + "&startTime=10:00&stopTime=11:00" + length)
+		expectStatus(t, w, http.StatusFound)
+
+		body := w.Body.String()
+		if !strings.Contains(body, "/booking/move?id=42&room=") {
+			t.Errorf("length %q: no move links", length)
+		}
+		if strings.Contains(body, `"/book?id=`) {
+			t.Errorf("length %q: book links while moving", length)
+		}
+	}
+}
+
+func TestMoveDefaultsToday(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+
+	expectRedirect(t, a.get("/book?id=2&date="+a.date+"&start=10:00&stop=11:00"), "/")
+	events := a.ownEvents()
+	if len(events) != 1 {
+		t.Fatalf("%d bookings, want 1", len(events))
+	}
+
+	expectRedirect(t, a.get("/booking/move?id="+strconv.Itoa(events[0].Id)+"&start=21:00&stop=22:00"), "/")
+
+	events = a.ownEvents()
+	today := a.client.Now().Format("2006-01-02")
+	if len(events) != 1 || events[0].Start.Format("2006-01-02 15:04") != today+" 21:00" {
+		t.Errorf("moved to %v, want today 21:00", events)
+	}
+}
+
+func TestSavePreset(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+
+	search := url.Values{"date": {a.date}, "startTime": {"10:00"}, "stopTime": {"11:00"}, "needsPiano": {"needsPiano"}}
+	w := a.post("/presets", url.Values{"query": {search.Encode()}, "name": {"Klaver"}})
+	expectStatus(t, w, http.StatusSeeOther)
+
+	// back to the results
+	if got, want := w.Header().Get("Location"), "/search?"+search.Encode(); got != want {
+		t.Errorf("redirected to %q, want %q", got, want)
+	}
+
+	// saved without the date
+	body := a.get("/search").Body.String()
+	today := a.client.Now().Format("2006-01-02")
+	listed := url.Values{"date": {today}, "startTime": {"10:00"}, "stopTime": {"11:00"}, "needsPiano": {"needsPiano"}}
+	if !strings.Contains(body, html.EscapeString("/search?"+listed.Encode())) {
+		t.Error("preset is not listed for today")
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jtagcat/teinetahvel/tahvel"
+	ginutil "github.com/jtagcat/util/gin"
+	"github.com/rs/xid"
+	"go.etcd.io/bbolt"
+)
+
+// preset is a named search, without its date.
+type preset struct {
+	Id    string
+	Name  string
+	Query string // as in /search
+}
+
+// not saved with a preset
+var presetIgnored = []string{"date", "until", "move"}
+
+func presetQuery(query url.Values) string {
+	q := make(url.Values)
+	for k, vs := range query {
+		if !slices.Contains(presetIgnored, k) {
+			q[k] = vs
+		}
+	}
+
+	return q.Encode()
+}
+
+func (p *preset) values() url.Values {
+	q, _ := url.ParseQuery(p.Query)
+	return q
+}
+
+func (p *preset) SearchURL(date string) string {
+	q := p.values()
+	q.Set("date", date)
+
+	return "/search?" + q.Encode()
+}
+
+func (p *preset) Summary() string {
+	return searchSummary(p.values())
+}
+
+// searchSummary is eg. "18:00–19:30, klaver, maja D"
+func searchSummary(q url.Values) string {
+	s := []string{q.Get("startTime") + "–" + q.Get("stopTime")}
+	if length := q.Get("length"); length != "" {
+		s = append(s, length+" min")
+	}
+	if len(q["weekday"]) != 0 {
+		var days []string
+		for _, dS := range q["weekday"] {
+			if d, err := strconv.Atoi(dS); err == nil {
+				days = append(days, weekdayNames[time.Weekday(d)])
+			}
+		}
+		s = append(s, strings.Join(days, ", "))
+	}
+	if q.Get("needsPiano") != "" {
+		s = append(s, "klaver")
+	}
+	if n := len(q["equipment"]); n != 0 {
+		s = append(s, fmt.Sprintf("%d varustust", n))
+	}
+	if places := q.Get("minPlaces"); places != "" {
+		s = append(s, "≥"+places+" kohta")
+	}
+	if building := q.Get("building"); building != "" {
+		s = append(s, "maja "+building)
+	}
+	if text := q.Get("q"); text != "" {
+		s = append(s, "„"+text+"“")
+	}
+
+	return strings.Join(s, ", ")
+}
+
+func userPresets(db *bbolt.DB, userId int) (presets []preset, _ error) {
+	return presets, db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket([]byte("presets")).Get([]byte(strconv.Itoa(userId)))
+		if v == nil {
+			return nil
+		}
+
+		return json.Unmarshal(v, &presets)
+	})
+}
+
+// updatePresets replaces the user's presets with f(presets).
+func updatePresets(db *bbolt.DB, userId int, f func([]preset) ([]preset, error)) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("presets"))
+		key := []byte(strconv.Itoa(userId))
+
+		var presets []preset
+		if v := b.Get(key); v != nil {
+			if err := json.Unmarshal(v, &presets); err != nil {
+				return err
+			}
+		}
+
+		presets, err := f(presets)
+		if err != nil {
+			return err
+		}
+
+		presetsJ, err := json.Marshal(presets)
+		if err != nil {
+			return err
+		}
+
+		return b.Put(key, presetsJ)
+	})
+}
+
+func presetHandlers(gctx context.Context, r *gin.Engine, db *bbolt.DB, client *tahvel.Client) {
+	r.POST("/presets", ginutil.HandlerWithErr(func(c *gin.Context, g *ginutil.Context) (int, string) {
+		if !authed(c) {
+			return g.Redirect(http.StatusTemporaryRedirect, "/")
+		}
+
+		t := client.Session(g.Cookie("session"))
+		ctx, cancel := context.WithTimeout(gctx, 5*time.Second)
+		defer cancel()
+
+		user, status, errStr := sessionUser(ctx, c, db, t)
+		if user == nil {
+			return status, errStr
+		}
+
+		query, err := url.ParseQuery(c.PostForm("query"))
+		if err != nil {
+			return http.StatusBadRequest, "Vigane otsing"
+		}
+
+		p := preset{
+			Id:    xid.New().String(),
+			Name:  strings.TrimSpace(c.PostForm("name")),
+			Query: presetQuery(query),
+		}
+		if p.Name == "" {
+			return http.StatusBadRequest, "Anna eelseadele nimi"
+		}
+
+		if err := updatePresets(db, user.UserId, func(presets []preset) ([]preset, error) {
+			return append(presets, p), nil
+		}); err != nil {
+			return http.StatusInternalServerError, err.Error()
+		}
+
+		return g.Redirect(http.StatusSeeOther, "/search?"+query.Encode())
+	}))
+
+	r.GET("/presets/delete", ginutil.HandlerWithErr(func(c *gin.Context, g *ginutil.Context) (int, string) {
+		if !authed(c) {
+			return g.Redirect(http.StatusTemporaryRedirect, "/")
+		}
+
+		t := client.Session(g.Cookie("session"))
+		ctx, cancel := context.WithTimeout(gctx, 5*time.Second)
+		defer cancel()
+
+		user, status, errStr := sessionUser(ctx, c, db, t)
+		if user == nil {
+			return status, errStr
+		}
+
+		if err := updatePresets(db, user.UserId, func(presets []preset) ([]preset, error) {
+			return slices.DeleteFunc(presets, func(p preset) bool { return p.Id == c.Query("id") }), nil
+		}); err != nil {
+			return http.StatusInternalServerError, err.Error()
+		}
+
+		return g.Redirect(http.StatusTemporaryRedirect, "/search")
+	}))
+
+	r.GET("/presets/book", ginutil.HandlerWithErr(func(c *gin.Context, g *ginutil.Context) (int, string) {
+		if !authed(c) {
+			return g.Redirect(http.StatusTemporaryRedirect, "/")
+		}
+
+		t := client.Session(g.Cookie("session"))
+		ctx, cancel := context.WithTimeout(gctx, 20*time.Second)
+		defer cancel()
+
+		user, status, errStr := sessionUser(ctx, c, db, t)
+		if user == nil {
+			return status, errStr
+		}
+
+		presets, err := userPresets(db, user.UserId)
+		if err != nil {
+			return http.StatusInternalServerError, err.Error()
+		}
+		i := slices.IndexFunc(presets, func(p preset) bool { return p.Id == c.Query("id") })
+		if i == -1 {
+			return http.StatusNotFound, "Eelseadet ei leitud"
+		}
+
+		date := tahvel.Day(client.Now()).Start
+		if dateS := c.Query("date"); dateS != "" {
+			if date, err = client.Date(dateS); err != nil {
+				return http.StatusBadRequest, "Vigane kuupäev"
+			}
+		}
+		if err := client.CheckHorizon(date); err != nil {
+			return horizonError(client)
+		}
+
+		candidates, err := searchCandidates(ctx, db, t, user, presets[i].values(), date)
+		if err == nil {
+			_, err = bookFirst(ctx, t, candidates)
+		}
+		if err != nil {
+			var bad badSearch
+			if errors.As(err, &bad) {
+				return http.StatusUnprocessableEntity, bad.Error()
+			}
+			return upstreamError(c, "booking preset", err)
+		}
+
+		return g.Redirect(http.StatusTemporaryRedirect, "/")
+	}))
+}
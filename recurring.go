@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jtagcat/teinetahvel/tahvel"
+	ginutil "github.com/jtagcat/util/gin"
+	"github.com/jtagcat/util/std"
+	"github.com/rs/xid"
+	"go.etcd.io/bbolt"
+)
+
+const RECURRING_INTERVAL = 5 * time.Minute
+
+// failed occurrences are retried after RECURRING_INTERVAL, doubling up to this
+const RECURRING_MAX_BACKOFF = 6 * time.Hour
+
+// avoids booking the same occurrence twice
+var recurringMu sync.Mutex
+
+type (
+	// Booked by runRecurring as soon as each occurrence is within the booking horizon.
+	recurringRule struct {
+		Id       string
+		UserId   int
+		Weekdays []time.Weekday
+		Start    string          // 15:04
+		Stop     string          // 15:04
+		Until    string          // 2006-01-02, inclusive
+		Rooms    []recurringRoom // preferred first, the rest are fallbacks
+
+		Occurrences map[string]recurringOccurrence // by date
+	}
+	recurringRoom struct {
+		Id   int
+		Code string
+	}
+	recurringOccurrence struct {
+		Room  string // booked room code, empty if not booked
+		Error string
+		Tried time.Time
+		Tries int // failed, for backoff
+	}
+)
+
+var weekdayNames = map[time.Weekday]string{
+	time.Monday:    "E",
+	time.Tuesday:   "T",
+	time.Wednesday: "K",
+	time.Thursday:  "N",
+	time.Friday:    "R",
+	time.Saturday:  "L",
+	time.Sunday:    "P",
+}
+
+func (r *recurringRule) WeekdayStr() string {
+	var s []string
+	for _, d := range r.Weekdays {
+		s = append(s, weekdayNames[d])
+	}
+
+	return strings.Join(s, ", ")
+}
+
+func (r *recurringRule) RoomStr() string {
+	var s []string
+	for _, room := range r.Rooms {
+		s = append(s, room.Code)
+	}
+
+	return strings.Join(s, " → ")
+}
+
+// Failures returns dates from today, which could not be booked.
+func (r *recurringRule) Failures(today string) (failures []string) {
+	for date, o := range r.Occurrences {
+		if date >= today && o.Room == "" && o.Error != "" {
+			failures = append(failures, date+": "+o.Error)
+		}
+	}
+	slices.Sort(failures)
+
+	return
+}
+
+// due is when a failed occurrence is tried again.
+func (o recurringOccurrence) due() time.Time {
+	if o.Tries == 0 {
+		return o.Tried
+	}
+
+	backoff := RECURRING_INTERVAL << min(o.Tries-1, 10)
+	return o.Tried.Add(min(backoff, RECURRING_MAX_BACKOFF))
+}
+
+// pending returns dates that are bookable now, but not booked yet, nor backing off.
+func (r *recurringRule) pending(now time.Time, horizon int) (dates []string) {
+	today := now.Format("2006-01-02")
+	for d := 0; d <= horizon; d++ {
+		day := now.AddDate(0, 0, d)
+		date := day.Format("2006-01-02")
+
+		if date > r.Until {
+			break
+		}
+		if !slices.Contains(r.Weekdays, day.Weekday()) {
+			continue
+		}
+		if o := r.Occurrences[date]; o.Room != "" || now.Before(o.due()) {
+			continue
+		}
+		if date == today && r.Start <= now.Format("15:04") {
+			continue // missed
+		}
+
+		dates = append(dates, date)
+	}
+
+	return
+}
+
+// book tries rooms in order of preference, now is when tried
+func (r *recurringRule) book(ctx context.Context, t *tahvel.Tahvel, date string, now time.Time) (recurringOccurrence, error) {
+	o := recurringOccurrence{Tried: now, Tries: r.Occurrences[date].Tries + 1}
+
+	startT, err := t.DateTime(date, r.Start)
+	if err != nil {
+		return o, err
+	}
+	stopT, err := t.DateTime(date, r.Stop)
+	if err != nil {
+		return o, err
+	}
+	iv := tahvel.Interval{Start: startT, End: stopT}
+
+	for _, room := range r.Rooms {
+		err := t.CreateBooking(ctx, []int{room.Id}, iv)
+		if err == nil {
+			o.Room, o.Tries = room.Code, 0
+			return o, nil
+		}
+
+		if errors.Is(err, tahvel.ErrOccupied) || errors.Is(err, tahvel.ErrForbidden) {
+			continue
+		}
+
+		if errors.Is(err, tahvel.ErrSessionExpired) {
+			o.Error = SESSION_EXPIRED
+		} else {
+			o.Error = err.Error()
+		}
+		return o, err
+	}
+
+	o.Error = "Kõik ruumid olid kinni."
+	return o, nil
+}
+
+// prune deletes occurrences before today, reporting if any were.
+func (r *recurringRule) prune(today string) (pruned bool) {
+	for date := range r.Occurrences {
+		if date < today {
+			delete(r.Occurrences, date)
+			pruned = true
+		}
+	}
+
+	return
+}
+
+//
+
+func recurringRules(db *bbolt.DB) ([]recurringRule, error) {
+	return listJobs[recurringRule](db, "recurring_rules")
+}
+
+func userRecurringRules(db *bbolt.DB, userId int) ([]recurringRule, error) {
+	rules, err := recurringRules(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(rules, func(r recurringRule) bool { return r.UserId != userId }), nil
+}
+
+//
+
+func runRecurring(gctx context.Context, db *bbolt.DB, client *tahvel.Client) {
+	recurringMu.Lock()
+	defer recurringMu.Unlock()
+
+	rules, err := recurringRules(db)
+	if err != nil {
+		slog.Error("listing recurring rules", std.SlogErr(err))
+		return
+	}
+
+	now := client.Now()
+	today := now.Format("2006-01-02")
+	sessions := newJobSessions(db, client)
+
+	for _, rule := range rules {
+		if rule.Until < today {
+			if err := deleteJob(db, "recurring_rules", rule.UserId, rule.Id); err != nil {
+				slog.Error("deleting expired recurring rule", slog.String("rule", rule.Id), std.SlogErr(err))
+			}
+			if err := forgetSession(db, rule.UserId); err != nil {
+				slog.Error("forgetting session", std.SlogErr(err))
+			}
+			continue
+		}
+
+		pruned := rule.prune(today)
+
+		ctx, cancel := context.WithTimeout(gctx, time.Minute)
+		t, user := sessions.get(ctx, rule.UserId)
+
+		pending := rule.pending(now, client.BookingHorizon())
+		if rule.Occurrences == nil {
+			rule.Occurrences = make(map[string]recurringOccurrence)
+		}
+
+		for _, date := range pending {
+			if user == nil {
+				// no request was made, retried as soon as the user logs in again
+				rule.Occurrences[date] = recurringOccurrence{Error: SESSION_EXPIRED, Tried: now}
+				continue
+			}
+
+			o, err := rule.book(ctx, t, date, now)
+			rule.Occurrences[date] = o
+
+			if o.Room != "" {
+				slog.Info("booked recurring", slog.String("rule", rule.Id), slog.String("date", date), slog.String("room", o.Room))
+			} else {
+				slog.Warn("booking recurring", slog.String("rule", rule.Id), slog.String("date", date), slog.String("reason", o.Error), std.SlogErr(err))
+			}
+
+			if errors.Is(err, tahvel.ErrSessionExpired) {
+				sessions.expired(rule.UserId)
+				user = nil
+			}
+		}
+
+		cancel()
+
+		if len(pending) == 0 && !pruned {
+			continue
+		}
+		if err := putJob(db, "recurring_rules", rule.Id, rule, true); err != nil {
+			slog.Error("saving recurring rule", slog.String("rule", rule.Id), std.SlogErr(err))
+		}
+	}
+}
+
+//
+
+func recurringHandlers(gctx context.Context, r *gin.Engine, db *bbolt.DB, client *tahvel.Client) {
+	r.GET("/recurring", ginutil.HandlerWithErr(func(c *gin.Context, g *ginutil.Context) (int, string) {
+		if !authed(c) {
+			return g.Redirect(http.StatusTemporaryRedirect, "/")
+		}
+
+		t := client.Session(g.Cookie("session"))
+		ctx, cancel := context.WithTimeout(gctx, 5*time.Second)
+		defer cancel()
+
+		user, status, errStr := sessionUser(ctx, c, db, t)
+		if user == nil {
+			return status, errStr
+		}
+
+		rules, err := userRecurringRules(db, user.UserId)
+		if err != nil {
+			return http.StatusInternalServerError, err.Error()
+		}
+
+		now := client.Now()
+
+		return g.HTML(http.StatusOK, "recurring.html", gin.H{
+			"rules":   rules,
+			"today":   now.Format("2006-01-02"),
+			"until":   now.AddDate(0, 4, 0).Format("2006-01-02"),
+			"horizon": client.BookingHorizon(),
+		})
+	}))
+
+	r.POST("/recurring", ginutil.HandlerWithErr(func(c *gin.Context, g *ginutil.Context) (int, string) {
+		if !authed(c) {
+			return g.Redirect(http.StatusTemporaryRedirect, "/")
+		}
+
+		t := client.Session(g.Cookie("session"))
+		ctx, cancel := context.WithTimeout(gctx, 20*time.Second)
+		defer cancel()
+
+		user, status, errStr := sessionUser(ctx, c, db, t)
+		if user == nil {
+			return status, errStr
+		}
+
+		rule := recurringRule{
+			Id:     xid.New().String(),
+			UserId: user.UserId,
+			Start:  c.PostForm("startTime"),
+			Stop:   c.PostForm("stopTime"),
+			Until:  c.PostForm("until"),
+
+			Occurrences: make(map[string]recurringOccurrence),
+		}
+
+		for _, dS := range c.PostFormArray("weekday") {
+			d, err := strconv.Atoi(dS)
+			if err != nil || d < 0 || d > 6 {
+				return http.StatusBadRequest, "Vigane nädalapäev"
+			}
+			rule.Weekdays = append(rule.Weekdays, time.Weekday(d))
+		}
+		if len(rule.Weekdays) == 0 {
+			return http.StatusBadRequest, "Vali vähemalt üks nädalapäev"
+		}
+
+		startT, err := time.Parse("15:04", rule.Start)
+		if err != nil {
+			return http.StatusBadRequest, "Vigane algusaeg"
+		}
+		stopT, err := time.Parse("15:04", rule.Stop)
+		if err != nil || !stopT.After(startT) {
+			return http.StatusBadRequest, "Vigane lõpuaeg"
+		}
+		if _, err := time.Parse("2006-01-02", rule.Until); err != nil {
+			return http.StatusBadRequest, "Vigane lõppkuupäev"
+		}
+
+		rooms, err := t.GetRooms(ctx, client.Now())
+		if err != nil {
+			return upstreamError(c, "listing rooms", err)
+		}
+
+		for _, code := range strings.Split(c.PostForm("rooms"), ",") {
+			code = strings.TrimSpace(code)
+			if code == "" {
+				continue
+			}
+
+			i := slices.IndexFunc(rooms, func(r tahvel.Room) bool { return strings.EqualFold(r.OnlyCode(), code) })
+			if i == -1 {
+				return http.StatusBadRequest, fmt.Sprintf("Ruumi %s ei leitud", code)
+			}
+			rule.Rooms = append(rule.Rooms, recurringRoom{Id: rooms[i].Id, Code: rooms[i].OnlyCode()})
+		}
+		if len(rule.Rooms) == 0 {
+			return http.StatusBadRequest, "Sisesta vähemalt üks ruum"
+		}
+
+		if err := putJob(db, "recurring_rules", rule.Id, rule, false); err != nil {
+			return http.StatusInternalServerError, err.Error()
+		}
+		if err := rememberSession(db, user.UserId, t.Session); err != nil {
+			return http.StatusInternalServerError, err.Error()
+		}
+
+		go runRecurring(gctx, db, client)
+
+		return g.Redirect(http.StatusFound, "/recurring")
+	}))
+
+	r.GET("/recurring/delete", ginutil.HandlerWithErr(func(c *gin.Context, g *ginutil.Context) (int, string) {
+		if !authed(c) {
+			return g.Redirect(http.StatusTemporaryRedirect, "/")
+		}
+
+		t := client.Session(g.Cookie("session"))
+		ctx, cancel := context.WithTimeout(gctx, 5*time.Second)
+		defer cancel()
+
+		user, status, errStr := sessionUser(ctx, c, db, t)
+		if user == nil {
+			return status, errStr
+		}
+
+		if err := deleteJob(db, "recurring_rules", user.UserId, c.Query("id")); err != nil {
+			return http.StatusNotFound, err.Error()
+		}
+		if err := forgetSession(db, user.UserId); err != nil {
+			slog.Error("forgetting session", std.SlogErr(err))
+		}
+
+		return g.Redirect(http.StatusTemporaryRedirect, "/recurring")
+	}))
+}
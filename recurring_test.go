@@ -0,0 +1,61 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRecurringPendingBackoff(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC) // Monday
+	date := "2026-10-20"
+
+	for _, tt := range []struct {
+		name    string
+		o       recurringOccurrence
+		pending bool
+	}{
+		{"untried", recurringOccurrence{}, true},
+		{"booked", recurringOccurrence{Room: "D108", Tried: now.Add(-time.Hour)}, false},
+		{"session expired", recurringOccurrence{Error: "Sessioon on aegunud", Tried: now}, true},
+		{"failed once, just now", recurringOccurrence{Error: "kinni", Tried: now, Tries: 1}, false},
+		{"failed once, after interval", recurringOccurrence{Error: "kinni", Tried: now.Add(-RECURRING_INTERVAL), Tries: 1}, true},
+		{"failed thrice, before backoff", recurringOccurrence{Error: "kinni", Tried: now.Add(-3 * RECURRING_INTERVAL), Tries: 3}, false},
+		{"failed thrice, after backoff", recurringOccurrence{Error: "kinni", Tried: now.Add(-4 * RECURRING_INTERVAL), Tries: 3}, true},
+		{"failed often, before max", recurringOccurrence{Error: "kinni", Tried: now.Add(-RECURRING_MAX_BACKOFF + time.Minute), Tries: 100}, false},
+		{"failed often, after max", recurringOccurrence{Error: "kinni", Tried: now.Add(-RECURRING_MAX_BACKOFF), Tries: 100}, true},
+	} {
+		rule := recurringRule{
+			Weekdays:    []time.Weekday{time.Tuesday},
+			Start:       "16:00",
+			Stop:        "18:00",
+			Until:       date,
+			Occurrences: map[string]recurringOccurrence{date: tt.o},
+		}
+
+		if got := slices.Contains(rule.pending(now, 7), date); got != tt.pending {
+			t.Errorf("%s: pending %v, want %v", tt.name, got, tt.pending)
+		}
+	}
+}
+
+func TestRecurringPrune(t *testing.T) {
+	rule := recurringRule{Occurrences: map[string]recurringOccurrence{
+		"2026-10-18": {Room: "D108"},
+		"2026-10-19": {Room: "D108"},
+		"2026-10-20": {Error: "kinni"},
+	}}
+
+	if !rule.prune("2026-10-19") {
+		t.Error("nothing pruned")
+	}
+	if _, ok := rule.Occurrences["2026-10-18"]; ok {
+		t.Error("past occurrence kept")
+	}
+	if len(rule.Occurrences) != 2 {
+		t.Errorf("%d occurrences left, want 2", len(rule.Occurrences))
+	}
+	if rule.prune("2026-10-19") {
+		t.Error("pruned again")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jtagcat/teinetahvel/tahvel"
+	bb "github.com/jtagcat/util/bbolt"
+	ginutil "github.com/jtagcat/util/gin"
+	"github.com/jtagcat/util/std"
+	"go.etcd.io/bbolt"
+)
+
+type userSettings struct {
+	// book the next best room if the chosen one was taken meanwhile
+	AutoFallback bool
+	// for waitlist notifications, last one used
+	Email string
+}
+
+func getSettings(db *bbolt.DB, userId int) (s userSettings) {
+	v := bb.Get(db, []byte("user_settings"), strconv.Itoa(userId))
+	if v == "" {
+		return
+	}
+
+	if err := json.Unmarshal([]byte(v), &s); err != nil {
+		slog.Warn("decoding user settings", slog.Int("userId", userId), std.SlogErr(err))
+	}
+
+	return
+}
+
+func putSettings(db *bbolt.DB, userId int, s userSettings) error {
+	sJ, err := json.Marshal(&s)
+	if err != nil {
+		return err
+	}
+
+	return bb.Put(db, []byte("user_settings"), strconv.Itoa(userId), string(sJ))
+}
+
+func settingsHandlers(gctx context.Context, r *gin.Engine, db *bbolt.DB, client *tahvel.Client) {
+	r.GET("/settings/autofallback", ginutil.HandlerWithErr(func(c *gin.Context, g *ginutil.Context) (int, string) {
+		if !authed(c) {
+			return g.Redirect(http.StatusTemporaryRedirect, "/")
+		}
+
+		t := client.Session(g.Cookie("session"))
+		ctx, cancel := context.WithTimeout(gctx, 5*time.Second)
+		defer cancel()
+
+		user, status, errStr := sessionUser(ctx, c, db, t)
+		if user == nil {
+			return status, errStr
+		}
+
+		s := getSettings(db, user.UserId)
+		s.AutoFallback = c.Query("on") == "1"
+		if err := putSettings(db, user.UserId, s); err != nil {
+			return http.StatusInternalServerError, err.Error()
+		}
+
+		return g.Redirect(http.StatusTemporaryRedirect, back(c, "/search"))
+	}))
+}
@@ -4,139 +4,227 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"iter"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/jtagcat/util/std"
 )
 
+// required, checked by main
 var BOOKINGNAME = os.Getenv("BOOKINGNAME")
 
-func init() {
-	if BOOKINGNAME == "" {
-		slog.Error("booking name must not be empty", slog.String("environment", "BOOKINGNAME"))
-		os.Exit(1)
-	}
-}
-
 type Booking struct {
 	Id        int
+	Version   int // optimistic locking, sent back on update and delete
 	Date      time.Time
 	DateStr   string
 	TimeStart string
 	TimeEnd   string
 	Rooms     []Room // Has only: Id, RoomCode
 	RoomStr   string
+	Time      Interval // Date with TimeStart and TimeEnd, zero if unparseable
 }
 
-func (t *Tahvel) Bookings(ctx context.Context, date time.Time) ([]Booking, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/hois_back/timetableevents?page=0&size=2000&from="+date.Format("2006-01-02T15:04:05.000")+"Z", nil)
-	if err != nil {
-		return nil, fmt.Errorf("crafting request: %w", err)
+func (b *Booking) interval() (Interval, error) {
+	if !b.Time.Valid() {
+		return Interval{}, &Error{Kind: ErrValidation, Message: fmt.Sprintf("unparseable booking time %q - %q", b.TimeStart, b.TimeEnd)}
 	}
-	req.AddCookie(&http.Cookie{Name: "SESSION", Value: t.Session})
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("performing request: %w", err)
-	}
+	return b.Time, nil
+}
 
-	if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
-		return nil, fmt.Errorf("bad status")
+func (b *Booking) RoomIds() (ids []int) {
+	for _, r := range b.Rooms {
+		ids = append(ids, r.Id)
 	}
 
-	var result struct {
-		Content []Booking
-		Last    bool
-	}
+	return
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("reading request body: %w", err)
-	}
+func (t *Tahvel) Bookings(ctx context.Context, date time.Time) ([]Booking, error) {
+	return collect(t.BookingsSeq(ctx, date))
+}
 
-	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, fmt.Errorf("decoding request: %w", err)
-	}
+// BookingsSeq streams bookings from date onwards, page by page.
+func (t *Tahvel) BookingsSeq(ctx context.Context, date time.Time) iter.Seq2[Booking, error] {
+	query := url.Values{"from": {t.wireTime(date)}}
 
-	if !result.Last {
-		return nil, fmt.Errorf("not last page, over 2000 rooms?")
-	}
+	return func(yield func(Booking, error) bool) {
+		for booking, err := range pages[Booking](ctx, t, "/hois_back/timetableevents", query) {
+			if err != nil {
+				yield(booking, err)
+				return
+			}
+
+			var roomStr []string
 
-	for i, booking := range result.Content {
-		var roomStr []string
+			for _, room := range booking.Rooms {
 
-		for _, room := range booking.Rooms {
+				var pianoStr string
+				switch pianoRooms[room.OnlyCode()] {
+				default:
+					roomStr = append(roomStr, room.RoomCode)
+					continue
+				case 1:
+					pianoStr = "🎹"
+				case 2:
+					pianoStr = "2️⃣"
+				}
 
-			var pianoStr string
-			switch pianoRooms[room.OnlyCode()] {
-			default:
-				roomStr = append(roomStr, room.RoomCode)
-				continue
-			case 1:
-				pianoStr = "🎹"
-			case 2:
-				pianoStr = "2️⃣"
+				roomStr = append(roomStr, pianoStr+" "+room.RoomCode)
 			}
 
-			roomStr = append(roomStr, pianoStr+" "+room.RoomCode)
+			booking.RoomStr = strings.Join(roomStr, ",")
+
+			booking.Date = t.fromWire(booking.Date)
+			booking.DateStr = booking.Date.Format("2006-01-02")
+
+			if iv, err := ParseTimes(booking.Date, booking.TimeStart+" - "+booking.TimeEnd); err != nil {
+				slog.Warn("found unusual booking time", slog.Int("id", booking.Id), std.SlogErr(err))
+			} else {
+				booking.Time = iv
+			}
+
+			if !yield(booking, nil) {
+				return
+			}
 		}
+	}
+}
 
-		result.Content[i].RoomStr = strings.Join(roomStr, ",")
+// CheckHorizon errors if date is in the past or too far in the future to book.
+// Compared by calendar date only.
+func (c *Client) CheckHorizon(date time.Time) error {
+	date = Day(date.In(c.location)).Start
+	today := Day(c.Now()).Start
 
-		result.Content[i].DateStr = booking.Date.Format("2006-01-02")
+	if date.Before(today) {
+		return &Error{Kind: ErrValidation, Message: "date is in the past"}
+	}
+	if last := today.AddDate(0, 0, c.bookingHorizon); date.After(last) {
+		return &Error{Kind: ErrValidation, Message: fmt.Sprintf("date is after %s, booking horizon is %d days", last.Format("2006-01-02"), c.bookingHorizon)}
 	}
 
-	return result.Content, nil
+	return nil
 }
 
-func (t *Tahvel) CreateBooking(ctx context.Context, roomId int, start, stop time.Time) error {
+// CheckOccupied errors with ErrOccupied if any of the rooms is booked during iv.
+// Tahvel reports that with 409; other refusals (eg. unknown room) stay ErrValidation.
+func (t *Tahvel) CheckOccupied(ctx context.Context, roomIds []int, iv Interval) error {
 	reqData := struct {
 		Rooms []int  `json:"rooms"`
 		Start string `json:"startTime"`
 		Stop  string `json:"endTime"`
 	}{
-		Rooms: []int{roomId},
-		Start: start.Format("2006-01-02T15:04:05.000") + "Z",
-		Stop:  stop.Format("2006-01-02T15:04:05.000") + "Z",
+		Rooms: roomIds,
+		Start: t.wireTime(iv.Start),
+		Stop:  t.wireTime(iv.End),
 	}
 	reqDataJ, err := json.Marshal(&reqData)
 	if err != nil {
 		return fmt.Errorf("marshalling request json body: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/hois_back/timetableevents/timetableTimeOccupied", bytes.NewReader(reqDataJ))
+	req, err := t.newRequest(ctx, http.MethodPost, "/hois_back/timetableevents/timetableTimeOccupied", bytes.NewReader(reqDataJ))
 	if err != nil {
 		return fmt.Errorf("crafting request: %w", err)
 	}
-	req.AddCookie(&http.Cookie{Name: "SESSION", Value: t.Session})
-
-	req.AddCookie(&http.Cookie{Name: "XSRF-TOKEN", Value: "teine"})
-	req.Header.Add("X-XSRF-TOKEN", "teine")
+	addXSRF(req)
 
 	req.Header.Add("Content-Type", "application/json;charset=UTF-8")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, body, err := t.do(req)
 	if err != nil {
-		return fmt.Errorf("performing request: %w", err)
+		return err
 	}
 
-	if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
-		return fmt.Errorf("bad status, kas ruum broneeriti vahetult enne ära?")
+	return checkStatus(resp, body)
+}
+
+// CreateBooking books all rooms for iv, or none of them.
+//
+//	Each room is checked separately first, so the error names the occupied one.
+//	The rooms are then booked as a single event, which Tahvel creates atomically.
+func (t *Tahvel) CreateBooking(ctx context.Context, roomIds []int, iv Interval) error {
+	if !iv.Valid() {
+		return &Error{Kind: ErrValidation, Message: "booking must end after it starts"}
+	}
+	roomIds = std.Deduplicate(roomIds)
+	if len(roomIds) == 0 {
+		return &Error{Kind: ErrValidation, Message: "no rooms to book"}
 	}
 
-	//
+	for _, roomId := range roomIds {
+		if err := t.CheckOccupied(ctx, []int{roomId}, iv); err != nil {
+			return fmt.Errorf("room %d: %w", roomId, err)
+		}
+	}
+
+	return t.saveEvent(ctx, http.MethodPost, "/hois_back/timetableevents", roomIds, iv, 0)
+}
+
+// UpdateBooking replaces rooms and time of an existing booking.
+// The booking is unchanged on error.
+// Errors with ErrOccupied if the new time is taken, ErrStale if b is not the latest version.
+//
+//	Tahvel responds 409 to both on PUT, so occupancy is checked first,
+//	leaving out the time b already holds in its own rooms.
+//	A room taken in between checking and saving is reported as ErrStale.
+func (t *Tahvel) UpdateBooking(ctx context.Context, b Booking, roomIds []int, iv Interval) error {
+	if !iv.Valid() {
+		return &Error{Kind: ErrValidation, Message: "booking must end after it starts"}
+	}
+	roomIds = std.Deduplicate(roomIds)
+	if len(roomIds) == 0 {
+		return &Error{Kind: ErrValidation, Message: "no rooms to book"}
+	}
 
-	date, _ := time.Parse("2006-01-02", start.Format("2006-01-02"))
+	old, err := b.interval()
+	if err != nil {
+		return err
+	}
+
+	for _, roomId := range roomIds {
+		added := []Interval{iv}
+		if slices.Contains(b.RoomIds(), roomId) {
+			added = iv.Subtract([]Interval{old})
+		}
+
+		for _, part := range added {
+			if err := t.CheckOccupied(ctx, []int{roomId}, part); err != nil {
+				return fmt.Errorf("room %d: %w", roomId, err)
+			}
+		}
+	}
+
+	err = t.saveEvent(ctx, http.MethodPut, "/hois_back/timetableevents/"+strconv.Itoa(b.Id), roomIds, iv, b.Version)
+	return withKind(err, ErrStale, ErrOccupied)
+}
+
+// saveEvent creates (POST) or updates (PUT) a single event
+func (t *Tahvel) saveEvent(ctx context.Context, method, path string, roomIds []int, iv Interval, version int) error {
+	date := Day(iv.Start.In(t.location)).Start
 
 	type ReqDataRoom struct {
 		Id int `json:"id"`
 	}
 
-	reqData2 := struct {
+	var rooms []ReqDataRoom
+	for _, roomId := range roomIds {
+		rooms = append(rooms, ReqDataRoom{Id: roomId})
+	}
+
+	reqData := struct {
+		Version  int           `json:"version"`
 		Single   bool          `json:"isSingleEvent"`
 		Public   bool          `json:"isPublic"`
 		Personal bool          `json:"isPersonal"`
@@ -146,63 +234,125 @@ func (t *Tahvel) CreateBooking(ctx context.Context, roomId int, start, stop time
 		Rooms    []ReqDataRoom `json:"rooms"`
 		Name     string        `json:"name"`
 	}{
+		Version: version,
+
 		Single:   true,
 		Public:   true,
 		Personal: true,
 
-		Date:  date.Format("2006-01-02T15:04:05.000") + "Z",
-		Start: start.Format("2006-01-02T15:04:05.000") + "Z",
-		Stop:  stop.Format("2006-01-02T15:04:05.000") + "Z",
+		Date:  t.wireTime(date),
+		Start: t.wireTime(iv.Start),
+		Stop:  t.wireTime(iv.End),
 
-		Rooms: []ReqDataRoom{{Id: roomId}},
+		Rooms: rooms,
 
 		Name: BOOKINGNAME,
 	}
-	reqDataJ, err = json.Marshal(&reqData2)
+	reqDataJ, err := json.Marshal(&reqData)
 	if err != nil {
 		return fmt.Errorf("marshalling request json body: %w", err)
 	}
 
-	req, err = http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/hois_back/timetableevents", bytes.NewReader(reqDataJ))
+	req, err := t.newRequest(ctx, method, path, bytes.NewReader(reqDataJ))
 	if err != nil {
 		return fmt.Errorf("crafting request: %w", err)
 	}
-	req.AddCookie(&http.Cookie{Name: "SESSION", Value: t.Session})
-
-	req.AddCookie(&http.Cookie{Name: "XSRF-TOKEN", Value: "teine"})
-	req.Header.Add("X-XSRF-TOKEN", "teine")
+	addXSRF(req)
 
 	req.Header.Add("Content-Type", "application/json;charset=UTF-8")
 
-	resp, err = http.DefaultClient.Do(req)
+	resp, body, err := t.do(req)
 	if err != nil {
-		return fmt.Errorf("performing request: %w", err)
+		return err
 	}
 
-	if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
-		return fmt.Errorf("bad status")
+	if err := checkStatus(resp, body); err != nil {
+		return err
 	}
 
 	return nil
 }
 
-func (t *Tahvel) CancelBooking(ctx context.Context, id string) error {
-	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, baseURL+"/hois_back/timetableevents/"+id+"?version=0", nil)
+// ExtendBooking moves the end of b later by d, if the rooms are free until then (see UpdateBooking).
+// It may cross midnight, but not last a day, as Tahvel lists only the clock ("23:00 - 01:00").
+func (t *Tahvel) ExtendBooking(ctx context.Context, b Booking, d time.Duration) error {
+	iv, err := b.interval()
 	if err != nil {
-		return fmt.Errorf("crafting request: %w", err)
+		return err
+	}
+
+	end := iv.End.Add(d)
+	if !end.Before(iv.Start.Add(24 * time.Hour)) {
+		return &Error{Kind: ErrValidation, Message: "booking would last a day or longer"}
+	}
+
+	iv.End = end
+	return t.UpdateBooking(ctx, b, b.RoomIds(), iv)
+}
+
+// EndBooking ends an ongoing booking now.
+func (t *Tahvel) EndBooking(ctx context.Context, b Booking) error {
+	iv, err := b.interval()
+	if err != nil {
+		return err
 	}
-	req.AddCookie(&http.Cookie{Name: "SESSION", Value: t.Session})
 
-	req.AddCookie(&http.Cookie{Name: "XSRF-TOKEN", Value: "teine"})
-	req.Header.Add("X-XSRF-TOKEN", "teine")
+	now := t.Now().Truncate(time.Minute)
+	if !now.After(iv.Start) {
+		return &Error{Kind: ErrValidation, Message: "booking has not started yet"}
+	}
+	if !now.Before(iv.End) {
+		return &Error{Kind: ErrValidation, Message: "booking has already ended"}
+	}
+
+	iv.End = now
+	return t.UpdateBooking(ctx, b, b.RoomIds(), iv)
+}
+
+var ErrMovedNotCancelled = errors.New("booked the new time, but cancelling the old booking failed")
+
+// MoveBooking moves b to other rooms and/or time.
+// The original booking is kept if the new one can't be made.
+//
+//	If the new booking overlaps the old one (same room, close enough time),
+//	it is updated in place. Otherwise the new booking is made first,
+//	and the old one is cancelled only after.
+func (t *Tahvel) MoveBooking(ctx context.Context, b Booking, roomIds []int, iv Interval) error {
+	old, err := b.interval()
+	if err != nil {
+		return err
+	}
+
+	if old.Overlaps(iv) && slices.ContainsFunc(roomIds, func(id int) bool { return slices.Contains(b.RoomIds(), id) }) {
+		return t.UpdateBooking(ctx, b, roomIds, iv)
+	}
+
+	if err := t.CreateBooking(ctx, roomIds, iv); err != nil {
+		return err
+	}
+
+	if err := t.CancelBooking(ctx, b.Id, b.Version); err != nil {
+		return fmt.Errorf("%w: %w", ErrMovedNotCancelled, err)
+	}
+
+	return nil
+}
+
+// CancelBooking errors with ErrStale if the booking has been changed since version.
+func (t *Tahvel) CancelBooking(ctx context.Context, id, version int) error {
+	req, err := t.newRequest(ctx, http.MethodDelete, fmt.Sprintf("/hois_back/timetableevents/%d?version=%d", id, version), nil)
+	if err != nil {
+		return fmt.Errorf("crafting request: %w", err)
+	}
+	addXSRF(req)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, body, err := t.do(req)
 	if err != nil {
-		return fmt.Errorf("performing request: %w", err)
+		return err
 	}
 
-	if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
-		return fmt.Errorf("bad status")
+	if err := checkStatus(resp, body); err != nil {
+		return withKind(err, ErrStale, ErrOccupied)
 	}
 
 	return nil
@@ -0,0 +1,247 @@
+package tahvel_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jtagcat/teinetahvel/tahvel"
+	"github.com/jtagcat/teinetahvel/tahveltest"
+)
+
+// wallClock is t as the fake stores it.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+// Own booking 10:00 - 11:00 in room 1, someone else's 11:30 - 12:30.
+func TestUpdateBookingOccupiedOrStale(t *testing.T) {
+	tahvel.BOOKINGNAME = "teinetahvel test"
+	ctx := context.Background()
+
+	fake := tahveltest.NewServer()
+	defer fake.Close()
+	fake.AddUser(tahveltest.User{IDCode: "1"})
+	fake.AddRoom(tahveltest.Room{Id: 1, Code: "X001"})
+	fake.AddRoom(tahveltest.Room{Id: 2, Code: "X002"})
+
+	client := fake.TahvelClient()
+	session, err := fake.Login("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tv := client.Session(session)
+
+	date := tahvel.Day(client.Now().AddDate(0, 0, 1)).Start
+	at := func(clock string) time.Time {
+		at, err := tahvel.At(date, clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return at
+	}
+	wall := func(clock string) time.Time { return wallClock(at(clock)) }
+
+	fake.AddEvent(tahveltest.Event{Name: "Tund", Start: wall("11:30"), End: wall("12:30"), Rooms: []int{1}})
+	if err := tv.CreateBooking(ctx, []int{1}, tahvel.Interval{Start: at("10:00"), End: at("11:00")}); err != nil {
+		t.Fatal(err)
+	}
+
+	booking := func() tahvel.Booking {
+		bookings, err := tv.Bookings(ctx, date)
+		if err != nil || len(bookings) != 1 {
+			t.Fatalf("bookings %v: %v", bookings, err)
+		}
+		return bookings[0]
+	}
+
+	original := booking()
+
+	if err := tv.ExtendBooking(ctx, original, time.Hour); !errors.Is(err, tahvel.ErrOccupied) {
+		t.Errorf("extending into someone else's: %v, want ErrOccupied", err)
+	}
+	if err := tv.UpdateBooking(ctx, original, []int{2}, tahvel.Interval{Start: at("11:30"), End: at("12:00")}); err != nil {
+		t.Errorf("moving to a free room: %v", err)
+	}
+
+	// original is stale from here on
+	if err := tv.UpdateBooking(ctx, original, []int{1}, tahvel.Interval{Start: at("09:00"), End: at("10:00")}); !errors.Is(err, tahvel.ErrStale) {
+		t.Errorf("updating a stale version: %v, want ErrStale", err)
+	}
+
+	latest := booking()
+	if err := tv.UpdateBooking(ctx, latest, []int{2}, tahvel.Interval{Start: at("11:00"), End: at("12:30")}); err != nil {
+		t.Errorf("overlapping its own time: %v", err)
+	}
+	if err := tv.ExtendBooking(ctx, booking(), 30*time.Minute); err != nil {
+		t.Errorf("extending into free time: %v", err)
+	}
+}
+
+func TestCheckOccupied(t *testing.T) {
+	fake := tahveltest.NewServer()
+	defer fake.Close()
+	fake.AddUser(tahveltest.User{IDCode: "1"})
+	fake.AddRoom(tahveltest.Room{Id: 1, Code: "X001"})
+	fake.AddRoom(tahveltest.Room{Id: 2, Code: "X002"})
+
+	client := fake.TahvelClient()
+	session, err := fake.Login("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tv := client.Session(session)
+
+	date := tahvel.Day(client.Now().AddDate(0, 0, 1)).Start
+	iv, err := tahvel.ParseTimes(date, "10:00 - 11:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.AddEvent(tahveltest.Event{Name: "Tund", Start: wallClock(iv.Start), End: wallClock(iv.End), Rooms: []int{1}})
+
+	for _, tc := range []struct {
+		name  string
+		rooms []int
+		want  error
+	}{
+		{"free", []int{2}, nil},
+		{"occupied", []int{1}, tahvel.ErrOccupied},
+		{"unknown room", []int{9999}, tahvel.ErrValidation},
+	} {
+		err := tv.CheckOccupied(context.Background(), tc.rooms, iv)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: %v, want %v", tc.name, err, tc.want)
+		}
+	}
+}
+
+func TestExtendBookingPastMidnight(t *testing.T) {
+	tahvel.BOOKINGNAME = "teinetahvel test"
+	ctx := context.Background()
+
+	fake := tahveltest.NewServer()
+	defer fake.Close()
+	fake.AddUser(tahveltest.User{IDCode: "1"})
+	fake.AddRoom(tahveltest.Room{Id: 1, Code: "X001"})
+
+	client := fake.TahvelClient()
+	session, err := fake.Login("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tv := client.Session(session)
+
+	date := tahvel.Day(client.Now().AddDate(0, 0, 1)).Start
+	iv, err := tahvel.ParseTimes(date, "23:00 - 01:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tv.CreateBooking(ctx, []int{1}, iv); err != nil {
+		t.Fatal(err)
+	}
+
+	bookings, err := tv.Bookings(ctx, date)
+	if err != nil || len(bookings) != 1 {
+		t.Fatalf("bookings %v: %v", bookings, err)
+	}
+
+	if err := tv.ExtendBooking(ctx, bookings[0], 30*time.Minute); err != nil {
+		t.Fatalf("extending a booking past midnight: %v", err)
+	}
+	if events := fake.Events(); len(events) != 1 || !events[0].End.Equal(wallClock(iv.End.Add(30*time.Minute))) {
+		t.Errorf("extended to %v", events)
+	}
+
+	bookings, err = tv.Bookings(ctx, date)
+	if err != nil || len(bookings) != 1 {
+		t.Fatalf("bookings %v: %v", bookings, err)
+	}
+	if err := tv.ExtendBooking(ctx, bookings[0], 22*time.Hour); !errors.Is(err, tahvel.ErrValidation) {
+		t.Errorf("extending to a day: %v, want ErrValidation", err)
+	}
+}
+
+func TestCheckHorizon(t *testing.T) {
+	fake := tahveltest.NewServer()
+	defer fake.Close()
+
+	client := fake.TahvelClient(tahvel.WithBookingHorizon(3))
+	today := tahvel.Day(client.Now()).Start
+
+	for _, tc := range []struct {
+		name string
+		date time.Time
+		ok   bool
+	}{
+		{"yesterday", today.AddDate(0, 0, -1), false},
+		{"yesterday, last minute", today.Add(-time.Minute), false},
+		{"today", today, true},
+		{"today, last minute", today.AddDate(0, 0, 1).Add(-time.Minute), true},
+		{"last day", today.AddDate(0, 0, 3), true},
+		{"last day, last minute", today.AddDate(0, 0, 4).Add(-time.Minute), true},
+		{"after the last day", today.AddDate(0, 0, 4), false},
+		// compared in the client's location
+		{"today, in UTC", today.AddDate(0, 0, 1).Add(-time.Minute).UTC(), true},
+	} {
+		err := client.CheckHorizon(tc.date)
+		if tc.ok && err != nil || !tc.ok && !errors.Is(err, tahvel.ErrValidation) {
+			t.Errorf("%s (%s): %v", tc.name, tc.date, err)
+		}
+	}
+}
+
+func TestCreateBookingMultiRoom(t *testing.T) {
+	tahvel.BOOKINGNAME = "teinetahvel test"
+	ctx := context.Background()
+
+	fake := tahveltest.NewServer()
+	defer fake.Close()
+	fake.AddUser(tahveltest.User{IDCode: "1"})
+	for id := 1; id <= 3; id++ {
+		fake.AddRoom(tahveltest.Room{Id: id, Code: fmt.Sprintf("X%03d", id)})
+	}
+
+	client := fake.TahvelClient()
+	session, err := fake.Login("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tv := client.Session(session)
+
+	date := tahvel.Day(client.Now().AddDate(0, 0, 1)).Start
+	iv, err := tahvel.ParseTimes(date, "10:00 - 11:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	later, err := tahvel.ParseTimes(date, "12:00 - 13:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.AddEvent(tahveltest.Event{Name: "Tund", Start: wallClock(later.Start), End: wallClock(later.End), Rooms: []int{3}})
+
+	if err := tv.CreateBooking(ctx, []int{1, 2, 1}, iv); err != nil {
+		t.Fatal(err)
+	}
+	events := fake.Events()
+	if len(events) != 2 || !slices.Equal(events[1].Rooms, []int{1, 2}) ||
+		!events[1].Start.Equal(wallClock(iv.Start)) || !events[1].End.Equal(wallClock(iv.End)) {
+		t.Fatalf("got %v, want one event in rooms [1 2]", events)
+	}
+
+	// room 3 taken, nothing is booked
+	err = tv.CreateBooking(ctx, []int{2, 3}, later)
+	if !errors.Is(err, tahvel.ErrOccupied) || !strings.Contains(err.Error(), "room 3") {
+		t.Errorf("booking an occupied room: %v, want ErrOccupied for room 3", err)
+	}
+	if events := fake.Events(); len(events) != 2 {
+		t.Errorf("partially booked: %v", events)
+	}
+
+	if err := tv.CreateBooking(ctx, nil, later); !errors.Is(err, tahvel.ErrValidation) {
+		t.Errorf("booking no rooms: %v, want ErrValidation", err)
+	}
+}
@@ -0,0 +1,158 @@
+package tahvel
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultBaseURL = "https://tahvel.edu.ee"
+	// days ahead of today, inclusive
+	DefaultBookingHorizon = 14
+)
+
+type (
+	// Client holds configuration shared by all sessions.
+	Client struct {
+		baseURL        string
+		http           *http.Client
+		userAgent      string
+		timeout        time.Duration
+		bookingHorizon int
+		location       *time.Location
+	}
+	Option func(*Client)
+)
+
+func New(opts ...Option) *Client {
+	c := &Client{
+		baseURL:        DefaultBaseURL,
+		http:           http.DefaultClient,
+		bookingHorizon: DefaultBookingHorizon,
+	}
+
+	c.location, _ = time.LoadLocation(DefaultLocation) // embedded with time/tzdata
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// eg. https://tahvel.edu.ee, without /hois_back
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.http = hc
+	}
+}
+
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// Applied to each request separately, 0 disables.
+// Not applied to waiting for Mobile-ID or Smart-ID confirmation.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// How many days ahead of today Tahvel allows booking.
+func WithBookingHorizon(days int) Option {
+	return func(c *Client) {
+		c.bookingHorizon = days
+	}
+}
+
+// Tahvel's (and the school's) timezone.
+func WithLocation(loc *time.Location) Option {
+	return func(c *Client) {
+		c.location = loc
+	}
+}
+
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+func (c *Client) BookingHorizon() int {
+	return c.bookingHorizon
+}
+
+// Session binds an existing (cookie) session to the client.
+func (c *Client) Session(session string) *Tahvel {
+	return &Tahvel{Client: c, Session: session}
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+
+	return req, nil
+}
+
+// newRequest with session cookie
+func (t *Tahvel) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := t.Client.newRequest(ctx, method, path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.AddCookie(&http.Cookie{Name: "SESSION", Value: t.Session})
+
+	return req, nil
+}
+
+// Tahvel only checks that the cookie and header match.
+func addXSRF(req *http.Request) {
+	req.AddCookie(&http.Cookie{Name: "XSRF-TOKEN", Value: "teine"})
+	req.Header.Add("X-XSRF-TOKEN", "teine")
+}
+
+// do performs the request, returning the response with its body already read.
+func (c *Client) do(req *http.Request) (*http.Response, []byte, error) {
+	if c.timeout != 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), c.timeout)
+		defer cancel()
+
+		req = req.WithContext(ctx)
+	}
+
+	return c.doUntimed(req)
+}
+
+// doUntimed is do without the client timeout, for requests waiting on the user.
+func (c *Client) doUntimed(req *http.Request) (*http.Response, []byte, error) {
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("performing request: %w: %w", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp, nil, fmt.Errorf("reading request body: %w", err)
+	}
+
+	return resp, body, nil
+}
@@ -1,7 +1,5 @@
 package tahvel
 
-const baseURL = "https://tahvel.edu.ee"
-
 var roomAclMapping = map[string]string{
 	"D107": "harjutus",
 	"D108": "harjutus",
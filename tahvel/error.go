@@ -0,0 +1,114 @@
+package tahvel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrSessionExpired = errors.New("session expired")
+	ErrForbidden      = errors.New("forbidden")
+	ErrOccupied       = errors.New("already occupied")
+	ErrStale          = errors.New("changed elsewhere")
+	ErrValidation     = errors.New("validation error")
+	ErrUnavailable    = errors.New("upstream unavailable")
+)
+
+// Error is a non-2xx response from Tahvel,
+// or a request refused before sending (Status 0).
+//
+//	errors.Is(err, tahvel.ErrOccupied)
+type Error struct {
+	Kind    error // one of Err*
+	Status  int
+	Message string // Tahvel's own, may be empty
+}
+
+func (e *Error) Error() string {
+	s := e.Kind.Error()
+	if e.Status != 0 {
+		s += fmt.Sprintf(" (%d %s)", e.Status, http.StatusText(e.Status))
+	}
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+
+	return s
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// checkStatus returns nil for 2xx responses.
+func checkStatus(resp *http.Response, body []byte) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	err := &Error{Status: resp.StatusCode, Message: errorMessage(body)}
+
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		err.Kind = ErrSessionExpired
+	case http.StatusForbidden:
+		err.Kind = ErrForbidden
+	case http.StatusConflict:
+		err.Kind = ErrOccupied
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusPreconditionFailed, http.StatusUnprocessableEntity:
+		err.Kind = ErrValidation
+	default:
+		err.Kind = ErrUnavailable
+	}
+
+	return err
+}
+
+// withKind overrides the kind of *Error, where Tahvel's status is ambiguous.
+func withKind(err error, kind error, from ...error) error {
+	var tErr *Error
+	if !errors.As(err, &tErr) {
+		return err
+	}
+
+	for _, f := range from {
+		if tErr.Kind == f {
+			tErr.Kind = kind
+			break
+		}
+	}
+
+	return err
+}
+
+// {"_errors":[{"code":"main.messages.error.nopermission"}]}
+// or {"message": "…"}
+func errorMessage(body []byte) string {
+	var result struct {
+		Errors []struct {
+			Code    string
+			Message string
+		} `json:"_errors"`
+		Message string
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return ""
+	}
+
+	var messages []string
+	for _, e := range result.Errors {
+		if e.Message != "" {
+			messages = append(messages, e.Message)
+			continue
+		}
+		messages = append(messages, e.Code)
+	}
+	if result.Message != "" {
+		messages = append(messages, result.Message)
+	}
+
+	return strings.Join(messages, "; ")
+}
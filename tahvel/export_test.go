@@ -0,0 +1,3 @@
+package tahvel
+
+const PageSize = pageSize
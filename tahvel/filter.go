@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"slices"
 	"strings"
 	"time"
 	"unicode"
@@ -78,7 +79,9 @@ func (r *UserRole) ACLs() []string {
 	return acls
 }
 
-func (r *Room) hasAccess(db *bbolt.DB, roles []UserRole) bool {
+// HasAccess by roles, crowdsourced answers first.
+// Sets MissingACL if it is a guess.
+func (r *Room) HasAccess(db *bbolt.DB, roles []UserRole) bool {
 	if crowd := bb.Get(db, []byte("crowdsourced_room_acl"), fmt.Sprintf("%d:%s", r.Id, aclCompositeName(roles))); crowd != "" {
 		if crowd == "1" {
 			return true
@@ -111,24 +114,72 @@ func (r *Room) hasAccess(db *bbolt.DB, roles []UserRole) bool {
 	return false
 }
 
-// // if !r.needsOk(needsOneOf) {
-// // 	continue
-// // }
-// func (r *Room) hasOneOf(candidates []string) bool {
-// 	if len(oneOf) == 0 {
-// 		return true
-// 	}
+// Needs is what a room must have, zero needs nothing.
+type Needs struct {
+	Piano     bool
+	OneOf     []string // equipment classifier codes, any of
+	AllOf     []string // equipment classifier codes
+	MinPlaces int
+	Building  string // BuildingName, case-insensitive
+	Text      string // see Room.Matches
+}
+
+func (n Needs) metBy(r *Room) bool {
+	if n.Piano && r.PianoCount < 1 {
+		return false
+	}
+
+	if !r.hasOneOf(n.OneOf) || !r.hasAllOf(n.AllOf) {
+		return false
+	}
+
+	if r.Places < n.MinPlaces {
+		return false
+	}
+
+	if n.Building != "" && !strings.EqualFold(strings.TrimSpace(r.BuildingName), strings.TrimSpace(n.Building)) {
+		return false
+	}
+
+	return r.Matches(n.Text)
+}
+
+// Matches a search by room code ("d31" for D313) or name, case-insensitive.
+func (r *Room) Matches(text string) bool {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if text == "" {
+		return true
+	}
+
+	return strings.HasPrefix(strings.ToLower(r.OnlyCode()), text) ||
+		strings.Contains(strings.ToLower(r.RoomName), text)
+}
 
-// 	for _, roomHas := range r.FlatEquipment() {
-// 		for _, need := range oneOf {
-// 			if roomHas == need {
-// 				return true
-// 			}
-// 		}
-// 	}
+func (r *Room) hasOneOf(candidates []string) bool {
+	if len(candidates) == 0 {
+		return true
+	}
+
+	for _, roomHas := range r.FlatEquipment() {
+		if slices.Contains(candidates, roomHas) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Room) hasAllOf(needs []string) bool {
+	flat := r.FlatEquipment()
 
-// 	return false
-// }
+	for _, need := range needs {
+		if !slices.Contains(flat, need) {
+			return false
+		}
+	}
+
+	return true
+}
 
 func (r *Room) FlatEquipment() (flat []string) {
 	for _, e := range r.Equipment {
@@ -140,73 +191,123 @@ func (r *Room) FlatEquipment() (flat []string) {
 
 const DICKTRESHOLD = 5 * time.Hour
 
+// FilterRooms sorts rooms to good and conflicting with want.
+// Zero want.End means until the end of the day.
+//
+//	Bookings overlapping want by at most tolerance at either end
+//	are ignored, eg. a lesson ending at 10:01 does not conflict with 10:00.
 func FilterRooms(db *bbolt.DB, rooms []Room,
-	aclGroups []UserRole, needsPiano bool,
-	bookStart, bookStop string, fuzzy time.Duration,
+	aclGroups []UserRole, needs Needs,
+	want Interval, tolerance time.Duration,
 ) (good []Room, conflicting []Room, dicks []string) {
-	startT, _ := time.Parse("15:04", bookStart)
-	stopT, _ := time.Parse("15:04", bookStop)
-
-	startT.Add(fuzzy)
-	stopT.Add(-fuzzy)
-	stopIsSet := stopT.Unix() != -62167219200
+	openEnded := want
+	if want.End.IsZero() {
+		want.End = Day(want.Start).End
+	}
+	strict := tolerant(want, tolerance)
 
-room:
 	for _, r := range rooms {
-		if !r.IsUsedInStudy {
+		if !r.usable(db, aclGroups, needs) {
 			continue
 		}
 
-		if !r.hasAccess(db, aclGroups) {
-			continue
+		for _, b := range r.Busy {
+			if b.Duration() >= DICKTRESHOLD {
+				dicks = append(dicks, fmt.Sprintf("%s on %s broneeritud %s", r.RoomCode, b.Duration(), b))
+			}
 		}
 
-		if needsPiano && r.PianoCount < 1 {
+		if conflict, ok := r.conflict(strict); ok {
+			r.ConflictReason = fmt.Sprintf("%s on kinni %s (%s)", r.RoomCode, conflict, conflict.Duration())
+
+			if next, ok := r.nextFree(openEnded); ok {
+				r.NextFree, r.Alternative = next, next
+				if !openEnded.End.IsZero() {
+					r.Alternative.End = next.Start.Add(want.Duration())
+				}
+			}
+
+			conflicting = append(conflicting, r)
 			continue
 		}
 
-		for _, booking := range r.Times {
-			bStart, bStop, ok := strings.Cut(booking, " - ")
-			if !ok {
-				slog.Warn("found unusual booking time", slog.String("anomaly", booking))
-				continue
-			}
+		good = append(good, r)
+	}
 
-			bStartT, err := time.Parse("15:04", bStart)
-			if err != nil {
-				slog.Warn("found unusual booking time start", slog.String("anomaly", booking))
-				continue
-			}
-			bStopT, err := time.Parse("15:04", bStop)
-			if err != nil {
-				slog.Warn("found unusual booking time stop", slog.String("anomaly", booking))
-				continue
+	// soonest free first, never free last
+	slices.SortStableFunc(conflicting, func(a, b Room) int {
+		if a.NextFree.Valid() != b.NextFree.Valid() {
+			if a.NextFree.Valid() {
+				return -1
 			}
+			return 1
+		}
 
-			bDuration := bStopT.Sub(bStartT)
-			if bDuration >= DICKTRESHOLD {
-				dicks = append(dicks, fmt.Sprintf("%s on %s broneeritud %s", r.RoomCode, bDuration, booking))
-			}
+		return a.NextFree.Start.Compare(b.NextFree.Start)
+	})
 
-			// filtering out non-conflicting times
-			if bStopT.Before(startT) {
-				continue
-			}
-			if stopIsSet && bStartT.After(stopT) {
-				continue
-			}
+	return
+}
 
-			r.ConflictReason = fmt.Sprintf("%s on kinni %s (%s)", r.RoomCode, booking, bDuration)
-			conflicting = append(conflicting, r)
-			continue room
-		}
+// tolerant is want without tolerance at either end, see FilterRooms.
+func tolerant(want Interval, tolerance time.Duration) Interval {
+	strict := want.Shrink(tolerance)
+	if !strict.Valid() {
+		return want
+	}
 
-		good = append(good, r)
+	return strict
+}
+
+// FreeAt is whether the room would not be conflicting in FilterRooms.
+func (r *Room) FreeAt(want Interval, tolerance time.Duration) bool {
+	if want.End.IsZero() {
+		want.End = Day(want.Start).End
+	}
+
+	_, conflicting := r.conflict(tolerant(want, tolerance))
+	return !conflicting
+}
+
+// UsableRooms filters rooms by everything but time.
+func UsableRooms(db *bbolt.DB, rooms []Room, aclGroups []UserRole, needs Needs) (usable []Room) {
+	for _, r := range rooms {
+		if r.usable(db, aclGroups, needs) {
+			usable = append(usable, r)
+		}
 	}
 
 	return
 }
 
+// usable is everything but time.
+func (r *Room) usable(db *bbolt.DB, aclGroups []UserRole, needs Needs) bool {
+	if !r.IsUsedInStudy {
+		return false
+	}
+
+	if !r.HasAccess(db, aclGroups) {
+		return false
+	}
+
+	return needs.metBy(r)
+}
+
+// conflict is the first booking overlapping want.
+func (r *Room) conflict(want Interval) (Interval, bool) {
+	if !want.Valid() {
+		return Interval{}, false
+	}
+
+	for _, b := range r.Busy {
+		if b.Overlaps(want) {
+			return b, true
+		}
+	}
+
+	return Interval{}, false
+}
+
 func FilterEquipmentReferenced(equipment map[string]string, rooms []Room) map[string]string {
 	referenced := make(map[string]string)
 
@@ -0,0 +1,159 @@
+package tahvel
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+func testDB(t *testing.T) *bbolt.DB {
+	t.Helper()
+
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "test.db"), 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("crowdsourced_room_acl"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// testRoom is usable by anyone, busy at times on testDay.
+func testRoom(id int, times ...string) Room {
+	r := Room{Id: id, RoomCode: "T" + string(rune('0'+id)), IsUsedInStudy: true, Times: times}
+	r.parseTimes(Day(testDay))
+
+	return r
+}
+
+func roomIds(rooms []Room) (ids []int) {
+	for _, r := range rooms {
+		ids = append(ids, r.Id)
+	}
+
+	return
+}
+
+func TestFilterRoomsTolerance(t *testing.T) {
+	db := testDB(t)
+	want := iv(t, "10:00 - 11:00")
+
+	rooms := []Room{
+		testRoom(1, "09:00 - 10:01"), // within tolerance at start
+		testRoom(2, "10:59 - 12:00"), // within tolerance at end
+		testRoom(3, "09:00 - 10:02"),
+		testRoom(4, "10:58 - 12:00"),
+		testRoom(5, "10:30 - 10:45"),
+		testRoom(6, "08:00 - 10:00", "11:00 - 12:00"), // touching
+		testRoom(7),
+	}
+
+	for _, tc := range []struct {
+		tolerance         time.Duration
+		good, conflicting []int
+	}{
+		{time.Minute, []int{1, 2, 6, 7}, []int{3, 4, 5}},
+		{0, []int{6, 7}, []int{1, 2, 3, 4, 5}},
+		// more than half of want, ignored
+		{time.Hour, []int{6, 7}, []int{1, 2, 3, 4, 5}},
+	} {
+		good, conflicting, _ := FilterRooms(db, rooms, nil, Needs{}, want, tc.tolerance)
+
+		if got := roomIds(good); !equalIds(got, tc.good) {
+			t.Errorf("tolerance %s: good %v, want %v", tc.tolerance, got, tc.good)
+		}
+		if got := roomIds(conflicting); !equalIds(got, tc.conflicting) {
+			t.Errorf("tolerance %s: conflicting %v, want %v", tc.tolerance, got, tc.conflicting)
+		}
+
+		// same as FilterRooms
+		for _, r := range rooms {
+			if free := slices.Contains(tc.good, r.Id); r.FreeAt(want, tc.tolerance) != free {
+				t.Errorf("tolerance %s: room %d FreeAt is %t", tc.tolerance, r.Id, !free)
+			}
+		}
+	}
+}
+
+// equalIds ignores order, conflicting is sorted by NextFree.
+func equalIds(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	seen := make(map[int]int)
+	for _, id := range a {
+		seen[id]++
+	}
+	for _, id := range b {
+		seen[id]--
+	}
+	for _, n := range seen {
+		if n != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestFilterRoomsNextFree(t *testing.T) {
+	db := testDB(t)
+
+	_, conflicting, _ := FilterRooms(db, []Room{
+		testRoom(1, "09:00 - 10:30", "11:00 - 13:00"), // 10:30 - 11:00 is too short
+		testRoom(2, "10:00 - 24:00"),
+		testRoom(3, "09:30 - 10:15"),
+	}, nil, Needs{}, iv(t, "10:00 - 11:00"), time.Minute)
+
+	if got := roomIds(conflicting); !equalIds(got, []int{3, 1, 2}) || got[0] != 3 || got[2] != 2 {
+		t.Fatalf("conflicting %v, want soonest free first, never free last", got)
+	}
+
+	for _, tc := range []struct {
+		room              Room
+		next, alternative Interval
+	}{
+		{conflicting[0], iv(t, "10:15 - 24:00"), iv(t, "10:15 - 11:15")},
+		{conflicting[1], iv(t, "13:00 - 24:00"), iv(t, "13:00 - 14:00")},
+		{conflicting[2], Interval{}, Interval{}},
+	} {
+		if !intervalsEqual([]Interval{tc.room.NextFree, tc.room.Alternative}, []Interval{tc.next, tc.alternative}) {
+			t.Errorf("room %d: next free %v, alternative %v; want %v, %v", tc.room.Id, tc.room.NextFree, tc.room.Alternative, tc.next, tc.alternative)
+		}
+	}
+}
+
+func TestFilterRoomsOpenEnded(t *testing.T) {
+	db := testDB(t)
+	want := Interval{Start: iv(t, "10:00 - 11:00").Start} // until the end of the day
+
+	good, conflicting, _ := FilterRooms(db, []Room{
+		testRoom(1, "08:00 - 09:00"),
+		testRoom(2, "15:00 - 16:00"),
+		testRoom(3, "23:59 - 01:00"), // within tolerance of midnight
+	}, nil, Needs{}, want, time.Minute)
+
+	if got := roomIds(good); !equalIds(got, []int{1, 3}) {
+		t.Errorf("good %v, want [1 3]", got)
+	}
+	if len(conflicting) != 1 {
+		t.Fatalf("conflicting %v, want [2]", roomIds(conflicting))
+	}
+
+	// any window fits, even the one before the conflict
+	r := conflicting[0]
+	if want := iv(t, "10:00 - 15:00"); !intervalsEqual([]Interval{r.NextFree, r.Alternative}, []Interval{want, want}) {
+		t.Errorf("next free %v, alternative %v; want %v", r.NextFree, r.Alternative, want)
+	}
+}
@@ -0,0 +1,108 @@
+package tahvel
+
+import (
+	"slices"
+	"time"
+)
+
+// Interval is [Start, End), eg. a booking.
+type Interval struct {
+	Start, End time.Time
+}
+
+func (iv Interval) Duration() time.Duration {
+	return iv.End.Sub(iv.Start)
+}
+
+func (iv Interval) Valid() bool {
+	return iv.Start.Before(iv.End)
+}
+
+func (iv Interval) Overlaps(o Interval) bool {
+	return iv.Start.Before(o.End) && o.Start.Before(iv.End)
+}
+
+func (iv Interval) Contains(o Interval) bool {
+	return !o.Start.Before(iv.Start) && !o.End.After(iv.End)
+}
+
+// Intersect is the overlapping part, invalid if none.
+func (iv Interval) Intersect(o Interval) Interval {
+	if o.Start.After(iv.Start) {
+		iv.Start = o.Start
+	}
+	if o.End.Before(iv.End) {
+		iv.End = o.End
+	}
+
+	return iv
+}
+
+// Shrink moves both ends inwards by d.
+func (iv Interval) Shrink(d time.Duration) Interval {
+	return Interval{Start: iv.Start.Add(d), End: iv.End.Add(-d)}
+}
+
+// "10:00 - 11:30", as in Room.Times.
+func (iv Interval) String() string {
+	return iv.StartClock() + " - " + iv.EndClock()
+}
+
+func (iv Interval) StartClock() string {
+	return iv.Start.Format("15:04")
+}
+
+// EndClock is "24:00" when ending at midnight after Start.
+func (iv Interval) EndClock() string {
+	end := iv.End.Format("15:04")
+	if end == "00:00" && iv.End.After(iv.Start) && !Day(iv.Start).End.Before(iv.End) {
+		end = "24:00"
+	}
+
+	return end
+}
+
+// Merge sorts and joins overlapping or touching intervals.
+// Invalid intervals are dropped.
+func Merge(ivs []Interval) (merged []Interval) {
+	sorted := slices.DeleteFunc(slices.Clone(ivs), func(iv Interval) bool { return !iv.Valid() })
+	slices.SortFunc(sorted, func(a, b Interval) int { return a.Start.Compare(b.Start) })
+
+	for _, iv := range sorted {
+		if last := len(merged) - 1; last >= 0 && !iv.Start.After(merged[last].End) {
+			if iv.End.After(merged[last].End) {
+				merged[last].End = iv.End
+			}
+			continue
+		}
+
+		merged = append(merged, iv)
+	}
+
+	return
+}
+
+// Subtract is what remains of iv after removing busy, eg. free gaps.
+func (iv Interval) Subtract(busy []Interval) (free []Interval) {
+	cursor := iv.Start
+
+	for _, b := range Merge(busy) {
+		if !b.End.After(cursor) {
+			continue
+		}
+		if !b.Start.Before(iv.End) {
+			break
+		}
+
+		if b.Start.After(cursor) {
+			free = append(free, Interval{Start: cursor, End: b.Start})
+		}
+		cursor = b.End
+	}
+
+	if cursor.Before(iv.End) {
+		free = append(free, Interval{Start: cursor, End: iv.End})
+	}
+
+	return
+}
@@ -0,0 +1,96 @@
+package tahvel
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// testDay is an ordinary day, see time_test.go for DST.
+var testDay = time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+
+// iv parses "10:00 - 11:00" on testDay, crossing midnight as ParseTimes.
+func iv(t *testing.T, s string) Interval {
+	t.Helper()
+
+	parsed, err := ParseTimes(testDay, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return parsed
+}
+
+func ivs(t *testing.T, ss ...string) (parsed []Interval) {
+	t.Helper()
+
+	for _, s := range ss {
+		parsed = append(parsed, iv(t, s))
+	}
+
+	return
+}
+
+func intervalsEqual(a, b []Interval) bool {
+	return slices.EqualFunc(a, b, func(a, b Interval) bool {
+		return a.Start.Equal(b.Start) && a.End.Equal(b.End)
+	})
+}
+
+func TestMerge(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		in, want []Interval
+	}{
+		{"empty", nil, nil},
+		{"disjoint", ivs(t, "12:00 - 13:00", "10:00 - 11:00"), ivs(t, "10:00 - 11:00", "12:00 - 13:00")},
+		{"touching", ivs(t, "10:00 - 11:00", "11:00 - 12:00"), ivs(t, "10:00 - 12:00")},
+		{"overlapping", ivs(t, "11:00 - 13:00", "10:00 - 12:00"), ivs(t, "10:00 - 13:00")},
+		{"contained", ivs(t, "10:00 - 14:00", "11:00 - 12:00", "13:00 - 14:00"), ivs(t, "10:00 - 14:00")},
+		{"chain", ivs(t, "10:00 - 11:00", "12:00 - 13:00", "10:30 - 12:30"), ivs(t, "10:00 - 13:00")},
+		{"invalid dropped", []Interval{{Start: iv(t, "12:00 - 13:00").End, End: iv(t, "12:00 - 13:00").Start}}, nil},
+	} {
+		if got := Merge(tc.in); !intervalsEqual(got, tc.want) {
+			t.Errorf("%s: Merge = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSubtract(t *testing.T) {
+	day := Day(testDay)
+	yesterday := Interval{Start: day.Start.Add(-time.Hour), End: day.Start.Add(time.Hour)} // 23:00 - 01:00
+
+	for _, tc := range []struct {
+		name       string
+		busy, want []Interval
+	}{
+		{"free", nil, []Interval{day}},
+		{"middle", ivs(t, "10:00 - 11:00"), ivs(t, "00:00 - 10:00", "11:00 - 24:00")},
+		{"touching", ivs(t, "11:00 - 12:00", "10:00 - 11:00"), ivs(t, "00:00 - 10:00", "12:00 - 24:00")},
+		{"at day edges", ivs(t, "00:00 - 08:00", "22:00 - 24:00"), ivs(t, "08:00 - 22:00")},
+		{"past day edges", []Interval{yesterday, iv(t, "23:00 - 01:00")}, ivs(t, "01:00 - 23:00")},
+		{"whole day", ivs(t, "00:00 - 24:00"), nil},
+		{"outside", []Interval{{Start: day.End, End: day.End.Add(time.Hour)}}, []Interval{day}},
+	} {
+		if got := day.Subtract(tc.busy); !intervalsEqual(got, tc.want) {
+			t.Errorf("%s: Subtract = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestIntersect(t *testing.T) {
+	a := iv(t, "10:00 - 12:00")
+
+	if got, want := a.Intersect(iv(t, "11:00 - 13:00")), iv(t, "11:00 - 12:00"); !intervalsEqual([]Interval{got}, []Interval{want}) {
+		t.Errorf("overlapping: %v, want %v", got, want)
+	}
+	if got := a.Intersect(iv(t, "09:00 - 13:00")); !intervalsEqual([]Interval{got}, []Interval{a}) {
+		t.Errorf("containing: %v, want %v", got, a)
+	}
+	if got := a.Intersect(iv(t, "13:00 - 14:00")); got.Valid() {
+		t.Errorf("disjoint: %v is valid", got)
+	}
+	if got := a.Intersect(iv(t, "12:00 - 13:00")); got.Valid() {
+		t.Errorf("touching: %v is valid", got)
+	}
+}
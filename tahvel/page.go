@@ -0,0 +1,80 @@
+package tahvel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"maps"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const pageSize = 200
+
+// pages follows Tahvel's (Spring) paging until the last page.
+// Iteration stops after the first error.
+func pages[T any](ctx context.Context, t *Tahvel, path string, query url.Values) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+
+		for page := 0; ; page++ {
+			q := maps.Clone(query)
+			if q == nil {
+				q = make(url.Values)
+			}
+			q.Set("page", strconv.Itoa(page))
+			q.Set("size", strconv.Itoa(pageSize))
+
+			req, err := t.newRequest(ctx, http.MethodGet, path+"?"+q.Encode(), nil)
+			if err != nil {
+				yield(zero, fmt.Errorf("crafting request: %w", err))
+				return
+			}
+
+			resp, body, err := t.do(req)
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+
+			if err := checkStatus(resp, body); err != nil {
+				yield(zero, err)
+				return
+			}
+
+			var result struct {
+				Content []T
+				Last    bool
+			}
+
+			if err := json.Unmarshal(body, &result); err != nil {
+				yield(zero, fmt.Errorf("decoding request (page %d): %w", page, err))
+				return
+			}
+
+			for _, item := range result.Content {
+				if !yield(item, nil) {
+					return
+				}
+			}
+
+			if result.Last || len(result.Content) == 0 {
+				return
+			}
+		}
+	}
+}
+
+func collect[T any](seq iter.Seq2[T, error]) (items []T, _ error) {
+	for item, err := range seq {
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
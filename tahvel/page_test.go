@@ -0,0 +1,105 @@
+package tahvel_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jtagcat/teinetahvel/tahvel"
+	"github.com/jtagcat/teinetahvel/tahveltest"
+)
+
+// countingTransport counts requests.
+type countingTransport struct {
+	http.RoundTripper
+	n atomic.Int32
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.n.Add(1)
+	return t.RoundTripper.RoundTrip(req)
+}
+
+func TestGetRoomsPages(t *testing.T) {
+	for _, tc := range []struct {
+		rooms, requests int
+	}{
+		{0, 1},
+		{1, 1},
+		{tahvel.PageSize, 1},
+		{tahvel.PageSize + 1, 2},
+		{2*tahvel.PageSize + 50, 3},
+	} {
+		fake := tahveltest.NewServer()
+		fake.AddUser(tahveltest.User{IDCode: "1"})
+		for id := 1; id <= tc.rooms; id++ {
+			fake.AddRoom(tahveltest.Room{Id: id, Code: fmt.Sprintf("X%03d", id)})
+		}
+
+		transport := &countingTransport{RoundTripper: fake.Client().Transport}
+		client := fake.TahvelClient(tahvel.WithHTTPClient(&http.Client{Transport: transport}))
+
+		session, err := fake.Login("1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rooms, err := client.Session(session).GetRooms(context.Background(), client.Now())
+		if err != nil {
+			t.Fatalf("%d rooms: %v", tc.rooms, err)
+		}
+
+		if len(rooms) != tc.rooms {
+			t.Errorf("%d rooms: got %d", tc.rooms, len(rooms))
+		}
+		seen := make(map[int]bool)
+		for _, r := range rooms {
+			seen[r.Id] = true
+		}
+		if len(seen) != tc.rooms {
+			t.Errorf("%d rooms: %d unique", tc.rooms, len(seen))
+		}
+		if n := int(transport.n.Load()); n != tc.requests {
+			t.Errorf("%d rooms: %d requests, want %d", tc.rooms, n, tc.requests)
+		}
+
+		fake.Close()
+	}
+}
+
+// An empty page ends paging, even if it does not say it is the last.
+func TestGetRoomsEmptyPage(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		if n > 5 {
+			http.Error(w, "paged too far", http.StatusTeapot)
+			return
+		}
+
+		content := []map[string]any{}
+		if n == 1 {
+			content = append(content, map[string]any{"id": 1, "roomCode": "X001", "isUsedInStudy": true})
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]any{"content": content, "last": false})
+	}))
+	defer server.Close()
+
+	client := tahvel.New(tahvel.WithBaseURL(server.URL))
+	rooms, err := client.Session("test").GetRooms(context.Background(), client.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rooms) != 1 {
+		t.Errorf("got %d rooms, want 1", len(rooms))
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("%d requests, want 2", n)
+	}
+}
@@ -0,0 +1,77 @@
+package tahvel
+
+import (
+	"slices"
+	"time"
+)
+
+// score weights, see Rank
+const (
+	scorePiano     = 10 // per piano, up to 2
+	scoreEquipment = 5  // per wanted equipment
+	scoreFavourite = 30
+	scoreFreeAfter = 10 // at FREEAFTER_CAP or more
+	scoreSeat      = -1 // per 2 seats more than needed, up to 20
+)
+
+// free time after the booking is worth no more than this
+const FREEAFTER_CAP = 2 * time.Hour
+
+// Rank scores and sorts rooms available at want,
+// favourites first, then best first. favourites are room Ids.
+func Rank(rooms []Room, want Interval, needs Needs, favourites map[int]bool) {
+	for i := range rooms {
+		rooms[i].FreeAfter = rooms[i].freeAfter(want)
+		rooms[i].Favourite = favourites[rooms[i].Id]
+		rooms[i].Score = rooms[i].score(needs)
+	}
+
+	slices.SortStableFunc(rooms, func(a, b Room) int {
+		if a.Favourite != b.Favourite {
+			if a.Favourite {
+				return -1
+			}
+			return 1
+		}
+
+		return b.Score - a.Score
+	})
+}
+
+func (r *Room) score(needs Needs) (score int) {
+	score += min(r.PianoCount, 2) * scorePiano
+
+	flat := r.FlatEquipment()
+	for _, e := range append(slices.Clone(needs.OneOf), needs.AllOf...) {
+		if slices.Contains(flat, e) {
+			score += scoreEquipment
+		}
+	}
+
+	if r.Favourite {
+		score += scoreFavourite
+	}
+
+	score += int(min(r.FreeAfter, FREEAFTER_CAP) * scoreFreeAfter / FREEAFTER_CAP)
+
+	if extra := r.Places - max(needs.MinPlaces, 1); extra > 0 {
+		score += min(extra, 20) / 2 * scoreSeat
+	}
+
+	return
+}
+
+// freeAfter is how long r stays free after want ends.
+func (r *Room) freeAfter(want Interval) time.Duration {
+	if want.End.IsZero() {
+		return 0
+	}
+
+	for _, gap := range r.Free {
+		if !gap.Start.After(want.End) && gap.End.After(want.End) {
+			return gap.End.Sub(want.End)
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,55 @@
+package tahvel
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestRank(t *testing.T) {
+	want := iv(t, "10:00 - 11:00")
+	needs := Needs{OneOf: []string{"PROJ"}}
+
+	rooms := func() []Room {
+		free := testRoom(1) // free after for long, capped
+		pianos := testRoom(2, "11:00 - 12:00")
+		pianos.PianoCount = 3 // capped at 2
+		piano := testRoom(3, "11:00 - 12:00")
+		piano.PianoCount = 1
+		big := testRoom(4)
+		big.Places = 21 // 20 extra seats
+		big.Equipment = []EquipmentListing{{Equipment: "PROJ", EquipmentCount: 1}}
+		favourite := testRoom(5, "12:00 - 13:00")
+
+		return []Room{free, pianos, piano, big, favourite}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		reverse bool
+		order   []int
+	}{
+		{"in order", false, []int{5, 2, 1, 3, 4}},
+		// 1 and 3 tie, kept as given
+		{"reversed", true, []int{5, 2, 3, 1, 4}},
+	} {
+		rs := rooms()
+		if tc.reverse {
+			slices.Reverse(rs)
+		}
+
+		Rank(rs, want, needs, map[int]bool{5: true})
+
+		if got := roomIds(rs); !slices.Equal(got, tc.order) {
+			t.Errorf("%s: order %v, want %v", tc.name, got, tc.order)
+		}
+
+		scores := make(map[int]int)
+		for _, r := range rs {
+			scores[r.Id] = r.Score
+		}
+		if wantScores := map[int]int{1: 10, 2: 20, 3: 10, 4: 5, 5: 35}; !maps.Equal(scores, wantScores) {
+			t.Errorf("%s: scores %v, want %v", tc.name, scores, wantScores)
+		}
+	}
+}
@@ -4,10 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
+	"iter"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/jtagcat/util/std"
 )
 
 type (
@@ -17,13 +22,20 @@ type (
 		RoomName string // type
 		// BuildingCode string // dupe
 		BuildingName  string
-		Times         []string
-		Places        int  // aka seats
-		IsUsedInStudy bool // true = available
+		Times         []string // "10:00 - 11:30", on the date requested
+		Places        int      // aka seats
+		IsUsedInStudy bool     // true = available
 		Equipment     []EquipmentListing
 
 		// added for internal data passing
+		Busy           []Interval // parsed Times, unmerged
+		Free           []Interval // rest of the day
 		ConflictReason string
+		NextFree       Interval      // if conflicting: first free window from the searched start, long enough
+		Alternative    Interval      // if conflicting: NextFree cut to the searched length
+		FreeAfter      time.Duration // see Rank
+		Favourite      bool
+		Score          int
 		MissingACL     bool
 		PianoCount     int
 		// added at render, do not use elsewhere
@@ -36,61 +48,129 @@ type (
 )
 
 func (t *Tahvel) GetRooms(ctx context.Context, date time.Time) ([]Room, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/hois_back/timetableevents/rooms", nil)
-	if err != nil {
-		return nil, fmt.Errorf("crafting request: %w", err)
-	}
-	req.AddCookie(&http.Cookie{Name: "SESSION", Value: t.Session})
+	return collect(t.RoomsSeq(ctx, date))
+}
 
-	dateS := date.Format("2006-01-02T15:04:05.000") + "Z"
+// RoomsSeq streams rooms with their bookings (Times) on date, page by page.
+func (t *Tahvel) RoomsSeq(ctx context.Context, date time.Time) iter.Seq2[Room, error] {
+	day := Day(date.In(t.location))
+	dateS := t.wireTime(day.Start)
 
-	query := req.URL.Query()
+	query := make(url.Values)
 	for k, v := range map[string]string{
 		"isBusyRoom":       "false",
 		"isFreeRoom":       "true",
 		"isPartlyBusyRoom": "true",
-		"page":             "0",
-		"size":             "2000", // max
 		"thru":             dateS,
 		"from":             dateS,
 	} {
 		query.Add(k, v)
 	}
-	req.URL.RawQuery = query.Encode()
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("performing request: %w", err)
-	}
+	return func(yield func(Room, error) bool) {
+		for room, err := range pages[Room](ctx, t, "/hois_back/timetableevents/rooms", query) {
+			if err != nil {
+				yield(room, err)
+				return
+			}
 
-	if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
-		return nil, fmt.Errorf("bad status")
-	}
+			if count, ok := pianoRooms[room.OnlyCode()]; ok {
+				room.PianoCount = count
+			}
+
+			room.parseTimes(day)
 
-	var roomResult struct {
-		Content []Room
-		Last    bool
+			if !yield(room, nil) {
+				return
+			}
+		}
 	}
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("reading request body: %w", err)
+// how many days RoomsOn requests at once
+const concurrentDays = 4
+
+// RoomsOn gets rooms for each of dates concurrently, in the same order.
+func (t *Tahvel) RoomsOn(ctx context.Context, dates []time.Time) ([][]Room, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		sem      = make(chan struct{}, concurrentDays)
+	)
+
+	rooms := make([][]Room, len(dates))
+	for i, date := range dates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			dayRooms, err := t.GetRooms(ctx, date)
+			if err != nil {
+				once.Do(func() {
+					firstErr = fmt.Errorf("rooms on %s: %w", date.Format("2006-01-02"), err)
+					cancel()
+				})
+				return
+			}
+
+			rooms[i] = dayRooms
+		}()
 	}
-	if err := json.Unmarshal(body, &roomResult); err != nil {
-		return nil, fmt.Errorf("decoding request: %w", err)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
 	}
 
-	if !roomResult.Last {
-		return nil, fmt.Errorf("not last page, over 2000 rooms?")
+	return rooms, nil
+}
+
+// parseTimes fills Busy and Free on day.
+//
+//	Times crossing midnight ("23:00 - 01:00") are listed on both days,
+//	without saying which day the booking started. Both ends of day are busy then.
+func (r *Room) parseTimes(day Interval) {
+	r.Busy = nil
+	for _, booking := range r.Times {
+		iv, err := ParseTimes(day.Start, booking)
+		if err != nil {
+			slog.Warn("found unusual booking time", slog.String("room", r.RoomCode), slog.String("anomaly", booking), std.SlogErr(err))
+			continue
+		}
+
+		r.Busy = append(r.Busy, iv)
+		if iv.End.After(day.End) {
+			r.Busy = append(r.Busy, Interval{Start: day.Start, End: iv.End.AddDate(0, 0, -1)})
+		}
 	}
 
-	for i, room := range roomResult.Content {
-		if count, ok := pianoRooms[room.OnlyCode()]; ok {
-			roomResult.Content[i].PianoCount = count
+	r.Free = day.Subtract(r.Busy)
+}
+
+// nextFree is the first free window from want.Start, fitting want.
+// Any window fits an open-ended want (zero End).
+func (r *Room) nextFree(want Interval) (Interval, bool) {
+	for _, gap := range r.Free {
+		if !gap.End.After(want.Start) {
+			continue
+		}
+		if gap.Start.Before(want.Start) {
+			gap.Start = want.Start
+		}
+
+		if want.End.IsZero() || gap.Duration() >= want.Duration() {
+			return gap, true
 		}
 	}
 
-	return roomResult.Content, nil
+	return Interval{}, false
 }
 
 func (r *Room) OnlyCode() string {
@@ -98,18 +178,22 @@ func (r *Room) OnlyCode() string {
 	return c
 }
 
-func GetEquipment(ctx context.Context) (map[string]string, error) {
+func (c *Client) GetEquipment(ctx context.Context) (map[string]string, error) {
 	equipment := make(map[string]string)
 
 	for _, class := range []string{"SEADMED"} {
-		resp, err := http.Get(baseURL + "/hois_back/autocomplete/classifiers?mainClassCode=" + class)
+		req, err := c.newRequest(ctx, http.MethodGet, "/hois_back/autocomplete/classifiers?mainClassCode="+class, nil)
 		if err != nil {
-			return nil, fmt.Errorf("getting equipment: %w", err)
+			return nil, fmt.Errorf("crafting request: %w", err)
 		}
 
-		body, err := io.ReadAll(resp.Body)
+		resp, body, err := c.do(req)
 		if err != nil {
-			return nil, fmt.Errorf("reading equipment list: %w", err)
+			return nil, fmt.Errorf("getting equipment: %w", err)
+		}
+
+		if err := checkStatus(resp, body); err != nil {
+			return nil, fmt.Errorf("getting equipment: %w", err)
 		}
 
 		type (
@@ -0,0 +1,22 @@
+package tahvel
+
+import "testing"
+
+func TestParseTimesPastMidnight(t *testing.T) {
+	for _, tc := range []struct {
+		times []string
+		free  []string
+	}{
+		{[]string{"10:00 - 11:00"}, []string{"00:00 - 10:00", "11:00 - 24:00"}},
+		{[]string{"22:00 - 24:00"}, []string{"00:00 - 22:00"}},
+		// started either the day before or on the day
+		{[]string{"23:00 - 01:00"}, []string{"01:00 - 23:00"}},
+		{[]string{"23:00 - 01:00", "12:00 - 13:00"}, []string{"01:00 - 12:00", "13:00 - 23:00"}},
+	} {
+		r := testRoom(1, tc.times...)
+
+		if want := ivs(t, tc.free...); !intervalsEqual(r.Free, want) {
+			t.Errorf("%v: free %v, want %v", tc.times, r.Free, want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package tahvel_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jtagcat/teinetahvel/tahvel"
+	"github.com/jtagcat/teinetahvel/tahveltest"
+)
+
+// More days than are requested at once, each with one event at a different hour.
+func TestRoomsOn(t *testing.T) {
+	ctx := context.Background()
+
+	fake := tahveltest.NewServer()
+	defer fake.Close()
+	fake.AddUser(tahveltest.User{IDCode: "1"})
+	fake.AddRoom(tahveltest.Room{Id: 1, Code: "X001"})
+	fake.AddRoom(tahveltest.Room{Id: 2, Code: "X002"})
+
+	client := fake.TahvelClient()
+	session, err := fake.Login("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tv := client.Session(session)
+
+	today := tahvel.Day(client.Now()).Start
+	var (
+		dates []time.Time
+		want  []string
+	)
+	for i := range 6 {
+		date := today.AddDate(0, 0, i)
+		times := fmt.Sprintf("%02d:00 - %02d:00", 8+i, 9+i)
+		iv, err := tahvel.ParseTimes(date, times)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fake.AddEvent(tahveltest.Event{Name: "Tund", Start: wallClock(iv.Start), End: wallClock(iv.End), Rooms: []int{1}})
+
+		dates = append(dates, date)
+		want = append(want, times)
+	}
+
+	rooms, err := tv.RoomsOn(ctx, dates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != len(dates) {
+		t.Fatalf("got %d days, want %d", len(rooms), len(dates))
+	}
+	for i, dayRooms := range rooms {
+		if len(dayRooms) != 2 {
+			t.Errorf("day %d: got %d rooms, want 2", i, len(dayRooms))
+			continue
+		}
+		if !slices.Equal(dayRooms[0].Times, []string{want[i]}) || len(dayRooms[1].Times) != 0 {
+			t.Errorf("day %d: times %v and %v, want [%s] and none", i, dayRooms[0].Times, dayRooms[1].Times, want[i])
+		}
+	}
+
+	if _, err := client.Session("expired").RoomsOn(ctx, dates); !errors.Is(err, tahvel.ErrSessionExpired) {
+		t.Errorf("with an expired session: %v, want ErrSessionExpired", err)
+	}
+}
@@ -0,0 +1,58 @@
+package tahvel
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Slot is a bookable length of time in a room.
+type Slot struct {
+	Room Room
+	Interval
+	Free Interval // the free window Slot starts
+}
+
+// FindSlots finds where length fits within window,
+// one Slot per free window, at its earliest.
+//
+//	Sorted by start, then by the least free time left over.
+func FindSlots(db *bbolt.DB, rooms []Room,
+	aclGroups []UserRole, needs Needs,
+	window Interval, length time.Duration,
+) (slots []Slot) {
+	if length <= 0 {
+		return nil
+	}
+
+	for _, r := range rooms {
+		if !r.usable(db, aclGroups, needs) {
+			continue
+		}
+
+		for _, free := range r.Free {
+			fits := free.Intersect(window)
+			if fits.Duration() < length {
+				continue
+			}
+
+			slots = append(slots, Slot{
+				Room:     r,
+				Interval: Interval{Start: fits.Start, End: fits.Start.Add(length)},
+				Free:     free,
+			})
+		}
+	}
+
+	slices.SortStableFunc(slots, func(a, b Slot) int {
+		if c := a.Start.Compare(b.Start); c != 0 {
+			return c
+		}
+
+		return cmp.Compare(a.Free.Duration(), b.Free.Duration())
+	})
+
+	return
+}
@@ -0,0 +1,45 @@
+package tahvel
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFindSlots(t *testing.T) {
+	db := testDB(t)
+
+	closed := testRoom(4)
+	closed.IsUsedInStudy = false
+
+	rooms := []Room{
+		testRoom(1, "10:00 - 10:20", "10:50 - 12:00"), // 10:20 - 10:50 is too short
+		testRoom(2, "08:00 - 12:15"),                  // fits exactly until the end of window
+		testRoom(3, "08:00 - 09:00", "11:00 - 12:00"),
+		closed,
+	}
+	window := iv(t, "09:00 - 13:00")
+
+	var got []string
+	for _, s := range FindSlots(db, rooms, nil, Needs{}, window, 45*time.Minute) {
+		got = append(got, fmt.Sprintf("%d %s", s.Room.Id, s.Interval))
+	}
+
+	want := []string{
+		"3 09:00 - 09:45", // ties by start, less free time left over first
+		"1 09:00 - 09:45",
+		"1 12:00 - 12:45", // equally free, kept in order
+		"3 12:00 - 12:45",
+		"2 12:15 - 13:00",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("slots\n%q\nwant\n%q", got, want)
+	}
+
+	for _, length := range []time.Duration{0, 4*time.Hour + time.Minute} {
+		if slots := FindSlots(db, rooms, nil, Needs{}, window, length); len(slots) != 0 {
+			t.Errorf("length %s: %d slots, want none", length, len(slots))
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package tahvel
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata"
+)
+
+// Tahvel sends and expects local wall clock, but labels it as UTC:
+//
+//	16:00 in Tallinn is "2006-01-02T16:00:00.000Z"
+const wireLayout = "2006-01-02T15:04:05.000"
+
+const DefaultLocation = "Europe/Tallinn"
+
+// wireTime formats t as Tahvel's local wall clock.
+func (c *Client) wireTime(t time.Time) string {
+	return t.In(c.location).Format(wireLayout) + "Z"
+}
+
+// fromWire moves a time decoded as UTC to the same wall clock in c's location.
+func (c *Client) fromWire(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), c.location)
+}
+
+func (c *Client) Location() *time.Location {
+	return c.location
+}
+
+func (c *Client) Now() time.Time {
+	return time.Now().In(c.location)
+}
+
+// Date returns midnight of date (2006-01-02) in c's location.
+func (c *Client) Date(date string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02", date, c.location)
+}
+
+// DateTime parses date (2006-01-02) and clock (15:04) in c's location.
+func (c *Client) DateTime(date, clock string) (time.Time, error) {
+	day, err := c.Date(date)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return At(day, clock)
+}
+
+// At returns clock (15:04) on day's date, in day's location.
+// "24:00" is midnight of the next day.
+//
+//	On DST transition days, nonexistent wall clock is normalized by time.Date.
+func At(day time.Time, clock string) (time.Time, error) {
+	if clock == "24:00" {
+		return time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, day.Location()), nil
+	}
+
+	hm, err := time.Parse("15:04", clock)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Date(day.Year(), day.Month(), day.Day(), hm.Hour(), hm.Minute(), 0, 0, day.Location()), nil
+}
+
+// Day is midnight to midnight of day's date, 23 or 25 hours on DST transition days.
+func Day(day time.Time) Interval {
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	return Interval{Start: start, End: start.AddDate(0, 0, 1)}
+}
+
+// ParseTimes parses Tahvel's "10:00 - 11:30" on day.
+// If it ends before it starts, it crosses midnight.
+func ParseTimes(day time.Time, s string) (Interval, error) {
+	startS, endS, ok := strings.Cut(s, " - ")
+	if !ok {
+		return Interval{}, fmt.Errorf("missing separator in %q", s)
+	}
+
+	start, err := At(day, strings.TrimSpace(startS))
+	if err != nil {
+		return Interval{}, fmt.Errorf("parsing start of %q: %w", s, err)
+	}
+	end, err := At(day, strings.TrimSpace(endS))
+	if err != nil {
+		return Interval{}, fmt.Errorf("parsing end of %q: %w", s, err)
+	}
+
+	if !end.After(start) {
+		end, _ = At(day.AddDate(0, 0, 1), strings.TrimSpace(endS))
+	}
+
+	return Interval{Start: start, End: end}, nil
+}
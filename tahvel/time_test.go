@@ -0,0 +1,115 @@
+package tahvel
+
+import (
+	"testing"
+	"time"
+)
+
+func mustLocation(t *testing.T) *time.Location {
+	t.Helper()
+
+	loc, err := time.LoadLocation(DefaultLocation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return loc
+}
+
+func TestParseTimes(t *testing.T) {
+	loc := mustLocation(t)
+	at := func(date, clock string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", date+" "+clock, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	for _, tc := range []struct {
+		day, s     string
+		start, end time.Time
+		duration   time.Duration
+	}{
+		{"2026-10-19", "10:00 - 11:30", at("2026-10-19", "10:00"), at("2026-10-19", "11:30"), 90 * time.Minute},
+		{"2026-10-19", "23:00 - 01:00", at("2026-10-19", "23:00"), at("2026-10-20", "01:00"), 2 * time.Hour},
+		{"2026-10-19", "22:00 - 24:00", at("2026-10-19", "22:00"), at("2026-10-20", "00:00"), 2 * time.Hour},
+		{"2026-10-19", "10:00 - 10:00", at("2026-10-19", "10:00"), at("2026-10-20", "10:00"), 24 * time.Hour},
+		// clocks go back at 04:00
+		{"2026-10-25", "02:00 - 05:00", at("2026-10-25", "02:00"), at("2026-10-25", "05:00"), 4 * time.Hour},
+		{"2026-10-25", "00:00 - 24:00", at("2026-10-25", "00:00"), at("2026-10-26", "00:00"), 25 * time.Hour},
+		// clocks go forward at 03:00
+		{"2027-03-28", "02:00 - 05:00", at("2027-03-28", "02:00"), at("2027-03-28", "05:00"), 2 * time.Hour},
+		{"2027-03-27", "23:00 - 05:00", at("2027-03-27", "23:00"), at("2027-03-28", "05:00"), 5 * time.Hour},
+	} {
+		iv, err := ParseTimes(at(tc.day, "00:00"), tc.s)
+		if err != nil {
+			t.Errorf("%s %q: %v", tc.day, tc.s, err)
+			continue
+		}
+
+		if !iv.Start.Equal(tc.start) || !iv.End.Equal(tc.end) {
+			t.Errorf("%s %q = %s - %s, want %s - %s", tc.day, tc.s, iv.Start, iv.End, tc.start, tc.end)
+		}
+		if iv.Duration() != tc.duration {
+			t.Errorf("%s %q lasts %s, want %s", tc.day, tc.s, iv.Duration(), tc.duration)
+		}
+	}
+
+	for _, s := range []string{"10:00-11:00", "25:00 - 26:00", "10:00 - ", ""} {
+		if _, err := ParseTimes(at("2026-10-19", "00:00"), s); err == nil {
+			t.Errorf("%q parsed without error", s)
+		}
+	}
+}
+
+func TestAtMidnight(t *testing.T) {
+	loc := mustLocation(t)
+
+	for _, date := range []string{"2026-10-19", "2026-10-25", "2027-03-28", "2026-12-31"} {
+		day, err := time.ParseInLocation("2006-01-02", date, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := At(day, "24:00")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := day.AddDate(0, 0, 1); !got.Equal(want) {
+			t.Errorf("At(%s, 24:00) = %s, want %s", date, got, want)
+		}
+		if got.Hour() != 0 || got.Minute() != 0 {
+			t.Errorf("At(%s, 24:00) = %s, not midnight", date, got)
+		}
+	}
+}
+
+func TestDay(t *testing.T) {
+	loc := mustLocation(t)
+
+	for _, tc := range []struct {
+		date     string
+		duration time.Duration
+	}{
+		{"2026-10-19", 24 * time.Hour},
+		{"2026-10-25", 25 * time.Hour},
+		{"2027-03-28", 23 * time.Hour},
+	} {
+		noon, err := time.ParseInLocation("2006-01-02 15:04", tc.date+" 12:00", loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		day := Day(noon)
+		if got := day.Start.Format("2006-01-02 15:04"); got != tc.date+" 00:00" {
+			t.Errorf("Day(%s) starts %s", tc.date, got)
+		}
+		if day.Duration() != tc.duration {
+			t.Errorf("Day(%s) lasts %s, want %s", tc.date, day.Duration(), tc.duration)
+		}
+		if !day.Contains(Interval{Start: noon, End: noon}) {
+			t.Errorf("Day(%s) does not contain noon", tc.date)
+		}
+	}
+}
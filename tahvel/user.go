@@ -5,14 +5,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 
 	"github.com/jtagcat/util/std"
 )
 
 type (
+	// Tahvel is a logged in session.
 	Tahvel struct {
+		*Client
 		Session string
 	}
 
@@ -33,7 +34,7 @@ type (
 )
 
 // phone format: +37255555555
-func AuthMid(ctx context.Context, idCode, phone string, authConfirmationCode chan<- string) (*Tahvel, error) {
+func (c *Client) AuthMid(ctx context.Context, idCode, phone string, authConfirmationCode chan<- string) (*Tahvel, error) {
 	reqData := struct {
 		IdCode string `json:"idcode"`
 		Phone  string `json:"mobileNumber"`
@@ -41,13 +42,41 @@ func AuthMid(ctx context.Context, idCode, phone string, authConfirmationCode cha
 		IdCode: idCode,
 		Phone:  phone,
 	}
-	reqDataJ, err := json.Marshal(&reqData)
+
+	return c.auth(ctx, "mId", &reqData, authConfirmationCode)
+}
+
+func (c *Client) AuthSid(ctx context.Context, idCode string, authConfirmationCode chan<- string) (*Tahvel, error) {
+	reqData := struct {
+		IdCode string `json:"idcode"`
+	}{
+		IdCode: idCode,
+	}
+
+	return c.auth(ctx, "sId", &reqData, authConfirmationCode)
+}
+
+// auth is the shared flow of Mobile-ID and Smart-ID:
+// the verification code is sent to authConfirmationCode,
+// then it blocks until the user confirms on their device.
+func (c *Client) auth(ctx context.Context, method string, reqData any, authConfirmationCode chan<- string) (*Tahvel, error) {
+	reqDataJ, err := json.Marshal(reqData)
 	if err != nil {
 		return nil, fmt.Errorf("marshalling request json body: %w", err)
 	}
 
-	resp, err := std.PostWithContext(ctx, http.DefaultClient, baseURL+"/hois_back/mIdLogin", "application/json;charset=utf-8", bytes.NewReader(reqDataJ))
+	req, err := c.newRequest(ctx, http.MethodPost, "/hois_back/"+method+"Login", bytes.NewReader(reqDataJ))
 	if err != nil {
+		return nil, fmt.Errorf("crafting request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json;charset=utf-8")
+
+	resp, respCodeB, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("starting flow: %w", err)
+	}
+
+	if err := checkStatus(resp, respCodeB); err != nil {
 		return nil, fmt.Errorf("starting flow: %w", err)
 	}
 
@@ -55,10 +84,6 @@ func AuthMid(ctx context.Context, idCode, phone string, authConfirmationCode cha
 		ChallengeID string `json:"challengeID"`
 	}
 
-	respCodeB, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("reading response code: %w", err)
-	}
 	if err := json.Unmarshal(respCodeB, &respCodeJ); err != nil {
 		return nil, fmt.Errorf("decoding response code: %w", err)
 	}
@@ -66,20 +91,21 @@ func AuthMid(ctx context.Context, idCode, phone string, authConfirmationCode cha
 	authConfirmationCode <- respCodeJ.ChallengeID
 	close(authConfirmationCode)
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/hois_back/mIdAuthentication", nil)
+	req, err = c.newRequest(ctx, http.MethodGet, "/hois_back/"+method+"Authentication", nil)
 	if err != nil {
 		return nil, fmt.Errorf("crafting request: %w", err)
 	}
 
-	req.Header.Add("Authorization", resp.Header["Authorization"][0])
+	req.Header.Add("Authorization", resp.Header.Get("Authorization"))
 
-	resp, err = http.DefaultClient.Do(req)
+	// long-polls until the user confirms, bounded by ctx
+	resp, body, err := c.doUntimed(req)
 	if err != nil {
-		return nil, fmt.Errorf("performing request: %w", err)
+		return nil, err
 	}
 
-	if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
-		return nil, fmt.Errorf("bad status")
+	if err := checkStatus(resp, body); err != nil {
+		return nil, err
 	}
 
 	tahvelSession := std.CookieByKey(resp.Cookies(), "SESSION")
@@ -87,28 +113,22 @@ func AuthMid(ctx context.Context, idCode, phone string, authConfirmationCode cha
 		return nil, fmt.Errorf("authentication response did not include token")
 	}
 
-	return &Tahvel{Session: tahvelSession}, nil
+	return c.Session(tahvelSession), nil
 }
 
 func (t *Tahvel) GetUser(ctx context.Context) (*User, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/hois_back/user", nil)
+	req, err := t.newRequest(ctx, http.MethodGet, "/hois_back/user", nil)
 	if err != nil {
 		return nil, fmt.Errorf("crafting request: %w", err)
 	}
-	req.AddCookie(&http.Cookie{Name: "SESSION", Value: t.Session})
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, body, err := t.do(req)
 	if err != nil {
-		return nil, fmt.Errorf("performing request: %w", err)
-	}
-
-	if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
-		return nil, fmt.Errorf("bad status")
+		return nil, err
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("reading request body: %w", err)
+	if err := checkStatus(resp, body); err != nil {
+		return nil, err
 	}
 
 	var user User
@@ -120,20 +140,16 @@ func (t *Tahvel) GetUser(ctx context.Context) (*User, error) {
 }
 
 func (t *Tahvel) Logout(ctx context.Context) error {
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/hois_back/logout", nil)
+	req, err := t.newRequest(ctx, http.MethodPost, "/hois_back/logout", nil)
 	if err != nil {
 		return fmt.Errorf("crafting request: %w", err)
 	}
-	req.AddCookie(&http.Cookie{Name: "SESSION", Value: t.Session})
+	addXSRF(req) // without it, Tahvel refuses with 403 and the session stays valid
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, body, err := t.do(req)
 	if err != nil {
-		return fmt.Errorf("performing request: %w", err)
-	}
-
-	if !(resp.StatusCode >= 400) {
-		return fmt.Errorf("bad status")
+		return err
 	}
 
-	return nil
+	return checkStatus(resp, body)
 }
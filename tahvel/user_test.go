@@ -0,0 +1,31 @@
+package tahvel_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jtagcat/teinetahvel/tahvel"
+	"github.com/jtagcat/teinetahvel/tahveltest"
+)
+
+func TestAuthOutlastsTimeout(t *testing.T) {
+	fake := tahveltest.NewServer()
+	defer fake.Close()
+	fake.AddUser(tahveltest.User{IDCode: "1", Phone: "+3725555555"})
+	fake.AuthDelay = 200 * time.Millisecond
+
+	client := fake.TahvelClient(tahvel.WithTimeout(50 * time.Millisecond))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	code := make(chan string, 1)
+	tv, err := client.AuthMid(ctx, "1", "+3725555555", code)
+	if err != nil {
+		t.Fatalf("confirmed after the client timeout: %v", err)
+	}
+	if _, err := tv.GetUser(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
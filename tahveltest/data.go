@@ -0,0 +1,117 @@
+package tahveltest
+
+import "time"
+
+type (
+	User struct {
+		IDCode    string
+		Phone     string // +37255555555
+		NoSmartID bool
+		FullName  string
+		UserId    int
+		PersonId  int
+		Roles     []Role
+	}
+	Role struct {
+		Id           int
+		SchoolCode   string
+		Role         string
+		StudentGroup string
+	}
+
+	Room struct {
+		Id           int
+		Code         string // eg. "D107 (harjutus)"
+		Name         string
+		BuildingName string
+		Places       int
+		NotInStudy   bool
+		Equipment    map[string]int // classifier code: count
+	}
+
+	// Event is a booking, either by a known User or by "someone else".
+	Event struct {
+		Id      int
+		Version int
+		Owner   string // User.IDCode, may be empty
+		Name    string
+		Start   time.Time // wall clock, location ignored
+		End     time.Time
+		Rooms   []int
+	}
+)
+
+func (e *Event) overlaps(start, end time.Time) bool {
+	return e.Start.Before(end) && start.Before(e.End)
+}
+
+func (e *Event) hasRoom(id int) bool {
+	for _, r := range e.Rooms {
+		if r == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Seed fills the server with a handful of rooms and demo users,
+// intended for local development.
+//
+//	Mobile-ID: 60001019906 +37200000766
+//	Smart-ID: 60001019906, 50001018865
+func (s *Server) Seed() {
+	for code, name := range map[string]string{
+		"KLAVER":  "_Klaver",
+		"TIIBKL":  "_Tiibklaver",
+		"TAHVEL":  "Tahvel",
+		"TRUMMID": "Trummikomplekt",
+		"PROJ":    "Projektor",
+	} {
+		s.AddEquipment(code, name)
+	}
+
+	s.AddUser(User{
+		IDCode:   "60001019906",
+		Phone:    "+37200000766",
+		FullName: "Mari-Liis Männik",
+		UserId:   1001,
+		PersonId: 2001,
+		Roles: []Role{
+			{Id: 223113, SchoolCode: "MUBA", Role: "ROLL_T"},
+			{Id: 300001, SchoolCode: "MUBA", Role: "ROLL_T", StudentGroup: "KL-21"},
+		},
+	})
+	s.AddUser(User{
+		IDCode:   "50001018865",
+		Phone:    "+37268000769",
+		FullName: "Jaak-Kristjan Jõeorg",
+		UserId:   1002,
+		PersonId: 2002,
+		Roles: []Role{
+			{Id: 300002, SchoolCode: "MUBA", Role: "ROLL_T", StudentGroup: "LP-22"},
+		},
+	})
+
+	for _, r := range []Room{
+		{Id: 1, Code: "D107 (harjutus)", Name: "harjutusklass", BuildingName: "D", Places: 2, Equipment: map[string]int{"KLAVER": 1}},
+		{Id: 2, Code: "D108 (harjutus)", Name: "harjutusklass", BuildingName: "D", Places: 2, Equipment: map[string]int{"KLAVER": 1}},
+		{Id: 3, Code: "D303", Name: "klaveriklass", BuildingName: "D", Places: 6, Equipment: map[string]int{"TIIBKL": 2}},
+		{Id: 4, Code: "B104", Name: "löökpilliklass", BuildingName: "B", Places: 8, Equipment: map[string]int{"KLAVER": 1, "TRUMMID": 2}},
+		{Id: 5, Code: "A212", Name: "ansambliklass", BuildingName: "A", Places: 20, Equipment: map[string]int{"TIIBKL": 2, "PROJ": 1}},
+		{Id: 6, Code: "C308", Name: "üldainete klass", BuildingName: "C", Places: 30, Equipment: map[string]int{"KLAVER": 1, "TAHVEL": 1, "PROJ": 1}},
+		{Id: 7, Code: "C999", Name: "remondis", BuildingName: "C", NotInStudy: true},
+	} {
+		s.AddRoom(r)
+	}
+
+	today := time.Now()
+	at := func(days, hour, min int) time.Time {
+		return time.Date(today.Year(), today.Month(), today.Day()+days, hour, min, 0, 0, time.UTC)
+	}
+
+	s.AddEvent(Event{Name: "Tund", Start: at(0, 10, 0), End: at(0, 11, 30), Rooms: []int{1}})
+	s.AddEvent(Event{Name: "Tund", Start: at(0, 14, 0), End: at(0, 20, 0), Rooms: []int{3}})
+	s.AddEvent(Event{Name: "Ansambel", Start: at(0, 16, 0), End: at(0, 18, 0), Rooms: []int{5}})
+	s.AddEvent(Event{Name: "Harjutamine", Start: at(1, 9, 0), End: at(1, 12, 0), Rooms: []int{2}})
+}
@@ -0,0 +1,688 @@
+// Package tahveltest is an in-process stand-in for the parts of Tahvel
+// (tahvel.edu.ee/hois_back) used by the tahvel package.
+package tahveltest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jtagcat/teinetahvel/tahvel"
+	"github.com/rs/xid"
+)
+
+// Tahvel sends local wall clock with a Z suffix.
+const wireLayout = "2006-01-02T15:04:05.000Z"
+
+type Server struct {
+	*httptest.Server
+
+	// Delay before mIdAuthentication returns, emulating the user confirming on their phone.
+	AuthDelay time.Duration
+
+	mu        sync.Mutex
+	users     map[string]*User  // by IDCode
+	sessions  map[string]string // SESSION: IDCode
+	pending   map[string]string // Authorization: IDCode
+	rooms     []Room
+	equipment map[string]string
+	events    map[int]*Event
+	lastId    int
+}
+
+// NewServer starts an empty server, see Seed.
+func NewServer() *Server {
+	s := &Server{
+		users:     make(map[string]*User),
+		sessions:  make(map[string]string),
+		pending:   make(map[string]string),
+		equipment: make(map[string]string),
+		events:    make(map[int]*Event),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /hois_back/mIdLogin", s.mIdLogin)
+	mux.HandleFunc("GET /hois_back/mIdAuthentication", s.authentication)
+	mux.HandleFunc("POST /hois_back/sIdLogin", s.sIdLogin)
+	mux.HandleFunc("GET /hois_back/sIdAuthentication", s.authentication)
+	mux.HandleFunc("GET /hois_back/user", s.withSession(s.user))
+	mux.HandleFunc("POST /hois_back/logout", s.withXSRF(s.withSession(s.logout)))
+	mux.HandleFunc("GET /hois_back/timetableevents", s.withSession(s.listEvents))
+	mux.HandleFunc("POST /hois_back/timetableevents", s.withXSRF(s.withSession(s.createEvent)))
+	mux.HandleFunc("PUT /hois_back/timetableevents/{id}", s.withXSRF(s.withSession(s.updateEvent)))
+	mux.HandleFunc("DELETE /hois_back/timetableevents/{id}", s.withXSRF(s.withSession(s.deleteEvent)))
+	mux.HandleFunc("POST /hois_back/timetableevents/timetableTimeOccupied", s.withXSRF(s.withSession(s.timeOccupied)))
+	mux.HandleFunc("GET /hois_back/timetableevents/rooms", s.withSession(s.listRooms))
+	mux.HandleFunc("GET /hois_back/autocomplete/classifiers", s.classifiers)
+
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// TahvelClient returns a tahvel.Client pointed at the server.
+func (s *Server) TahvelClient(opts ...tahvel.Option) *tahvel.Client {
+	return tahvel.New(append([]tahvel.Option{
+		tahvel.WithBaseURL(s.URL),
+		tahvel.WithHTTPClient(s.Server.Client()),
+	}, opts...)...)
+}
+
+func (s *Server) AddUser(u User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[u.IDCode] = &u
+}
+
+func (s *Server) AddRoom(r Room) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rooms = append(s.rooms, r)
+}
+
+func (s *Server) AddEquipment(code, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.equipment[code] = name
+}
+
+// AddEvent adds the event as-is, without checking for overlaps. Returns the new Id.
+func (s *Server) AddEvent(e Event) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastId++
+	e.Id = s.lastId
+	s.events[e.Id] = &e
+
+	return e.Id
+}
+
+func (s *Server) Events() (events []Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.events {
+		events = append(events, *e)
+	}
+	slices.SortFunc(events, func(a, b Event) int { return a.Id - b.Id })
+
+	return
+}
+
+// Login skips Mobile-ID, returning a SESSION for the user.
+func (s *Server) Login(idCode string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[idCode]; !ok {
+		return "", fmt.Errorf("unknown user %q", idCode)
+	}
+
+	session := xid.New().String()
+	s.sessions[session] = idCode
+
+	return session, nil
+}
+
+//
+
+// Tahvel's (Spring) error format
+func writeError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]any{
+		"_errors": []map[string]string{{"code": code}},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+type sessionHandler func(w http.ResponseWriter, r *http.Request, u *User)
+
+func (s *Server) withSession(next sessionHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("SESSION")
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, "main.messages.error.unauthorized")
+			return
+		}
+
+		s.mu.Lock()
+		idCode, ok := s.sessions[cookie.Value]
+		u := s.users[idCode]
+		s.mu.Unlock()
+
+		if !ok || u == nil {
+			writeError(w, http.StatusUnauthorized, "main.messages.error.unauthorized")
+			return
+		}
+
+		next(w, r, u)
+	}
+}
+
+func (s *Server) withXSRF(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("XSRF-TOKEN")
+		if err != nil || cookie.Value == "" || cookie.Value != r.Header.Get("X-XSRF-TOKEN") {
+			writeError(w, http.StatusForbidden, "main.messages.error.csrf")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+//
+
+func (s *Server) mIdLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IdCode string `json:"idcode"`
+		Phone  string `json:"mobileNumber"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "main.messages.error.validation")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[req.IdCode]
+	if !ok || u.Phone != req.Phone {
+		writeError(w, http.StatusBadRequest, "main.messages.error.mobileId.notMidClient")
+		return
+	}
+
+	s.startAuth(w, u, fmt.Sprintf("%04d", rand.IntN(10000)))
+}
+
+func (s *Server) sIdLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IdCode string `json:"idcode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "main.messages.error.validation")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[req.IdCode]
+	if !ok || u.NoSmartID {
+		writeError(w, http.StatusBadRequest, "main.messages.error.smartId.userAccountNotFound")
+		return
+	}
+
+	s.startAuth(w, u, fmt.Sprintf("%04d", rand.IntN(10000)))
+}
+
+// startAuth responds with the verification code, s.mu must be held.
+func (s *Server) startAuth(w http.ResponseWriter, u *User, challengeID string) {
+	authorization := "Bearer " + xid.New().String()
+	s.pending[authorization] = u.IDCode
+
+	w.Header().Set("Authorization", authorization)
+	writeJSON(w, http.StatusOK, map[string]string{
+		"challengeID": challengeID,
+	})
+}
+
+func (s *Server) authentication(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-r.Context().Done():
+		return
+	case <-time.After(s.AuthDelay):
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	authorization := r.Header.Get("Authorization")
+	idCode, ok := s.pending[authorization]
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "main.messages.error.unauthorized")
+		return
+	}
+	delete(s.pending, authorization)
+
+	session := xid.New().String()
+	s.sessions[session] = idCode
+
+	http.SetCookie(w, &http.Cookie{Name: "SESSION", Value: session, Path: "/", HttpOnly: true})
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) user(w http.ResponseWriter, r *http.Request, u *User) {
+	type role struct {
+		Id           int    `json:"id"`
+		SchoolCode   string `json:"schoolCode"`
+		Role         string `json:"role"`
+		StudentGroup string `json:"studentGroup"`
+	}
+
+	roles := []role{}
+	for _, r := range u.Roles {
+		roles = append(roles, role(r))
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"name":                    u.IDCode,
+		"user":                    u.UserId,
+		"person":                  u.PersonId,
+		"fullname":                u.FullName,
+		"users":                   roles,
+		"sessionTimeoutInSeconds": 60 * 60 * 3,
+	})
+}
+
+func (s *Server) logout(w http.ResponseWriter, r *http.Request, u *User) {
+	cookie, _ := r.Cookie("SESSION")
+
+	s.mu.Lock()
+	delete(s.sessions, cookie.Value)
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+//
+
+// page slices content like Spring's Page
+func page[T any](w http.ResponseWriter, r *http.Request, content []T) {
+	pageN, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	size, err := strconv.Atoi(r.URL.Query().Get("size"))
+	if err != nil || size <= 0 {
+		size = 20
+	}
+
+	start := min(pageN*size, len(content))
+	end := min(start+size, len(content))
+
+	totalPages := (len(content) + size - 1) / size
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"content":       append([]T{}, content[start:end]...),
+		"number":        pageN,
+		"size":          size,
+		"totalElements": len(content),
+		"totalPages":    totalPages,
+		"first":         pageN == 0,
+		"last":          pageN >= totalPages-1,
+	})
+}
+
+func (s *Server) roomCode(id int) string {
+	for _, room := range s.rooms {
+		if room.Id == id {
+			return room.Code
+		}
+	}
+
+	return ""
+}
+
+func (s *Server) listEvents(w http.ResponseWriter, r *http.Request, u *User) {
+	from, err := time.Parse(time.RFC3339, r.URL.Query().Get("from"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "main.messages.error.validation")
+		return
+	}
+	fromDate := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+
+	type (
+		eventRoom struct {
+			Id       int    `json:"id"`
+			RoomCode string `json:"roomCode"`
+		}
+		event struct {
+			Id        int         `json:"id"`
+			Version   int         `json:"version"`
+			Name      string      `json:"nameEt"`
+			Date      string      `json:"date"`
+			TimeStart string      `json:"timeStart"`
+			TimeEnd   string      `json:"timeEnd"`
+			Rooms     []eventRoom `json:"rooms"`
+		}
+	)
+
+	s.mu.Lock()
+	var events []event
+	for _, e := range s.events {
+		if e.Owner != u.IDCode || e.End.Before(fromDate) {
+			continue
+		}
+
+		ev := event{
+			Id:        e.Id,
+			Version:   e.Version,
+			Name:      e.Name,
+			Date:      time.Date(e.Start.Year(), e.Start.Month(), e.Start.Day(), 0, 0, 0, 0, time.UTC).Format(wireLayout),
+			TimeStart: e.Start.Format("15:04"),
+			TimeEnd:   e.End.Format("15:04"),
+			Rooms:     []eventRoom{},
+		}
+		for _, id := range e.Rooms {
+			ev.Rooms = append(ev.Rooms, eventRoom{Id: id, RoomCode: s.roomCode(id)})
+		}
+
+		events = append(events, ev)
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(events, func(a, b event) int { return a.Id - b.Id })
+
+	page(w, r, events)
+}
+
+// occupied returns the first room busy during start-end, -1 if none.
+// Event except is ignored (when updating it).
+func (s *Server) occupied(rooms []int, start, end time.Time, except int) int {
+	for _, id := range rooms {
+		for _, e := range s.events {
+			if e.Id != except && e.hasRoom(id) && e.overlaps(start, end) {
+				return id
+			}
+		}
+	}
+
+	return -1
+}
+
+func (s *Server) validRooms(rooms []int) bool {
+	if len(rooms) == 0 {
+		return false
+	}
+
+	for _, id := range rooms {
+		if s.roomCode(id) == "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+func parseRange(startS, endS string) (start, end time.Time, ok bool) {
+	start, err := time.Parse(time.RFC3339, startS)
+	if err != nil {
+		return
+	}
+	end, err = time.Parse(time.RFC3339, endS)
+	if err != nil {
+		return
+	}
+
+	return start, end, start.Before(end)
+}
+
+func (s *Server) timeOccupied(w http.ResponseWriter, r *http.Request, u *User) {
+	var req struct {
+		Rooms []int  `json:"rooms"`
+		Start string `json:"startTime"`
+		End   string `json:"endTime"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "main.messages.error.validation")
+		return
+	}
+
+	start, end, ok := parseRange(req.Start, req.End)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "main.messages.error.validation")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.validRooms(req.Rooms) {
+		writeError(w, http.StatusBadRequest, "main.messages.error.validation")
+		return
+	}
+	if s.occupied(req.Rooms, start, end, 0) != -1 {
+		writeError(w, http.StatusConflict, "timetable.timetableEvent.error.roomOccupied")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+type eventRequest struct {
+	Version int    `json:"version"`
+	Single  bool   `json:"isSingleEvent"`
+	Date    string `json:"date"`
+	Start   string `json:"startTime"`
+	End     string `json:"endTime"`
+	Rooms   []struct {
+		Id int `json:"id"`
+	} `json:"rooms"`
+	Name string `json:"name"`
+}
+
+// decodeEvent writes the error response itself
+func decodeEvent(w http.ResponseWriter, r *http.Request) (req eventRequest, rooms []int, start, end time.Time, ok bool) {
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "main.messages.error.validation")
+		return
+	}
+
+	start, end, ok = parseRange(req.Start, req.End)
+	if !ok || !req.Single || req.Name == "" {
+		writeError(w, http.StatusBadRequest, "main.messages.error.validation")
+		return req, nil, start, end, false
+	}
+
+	for _, room := range req.Rooms {
+		rooms = append(rooms, room.Id)
+	}
+
+	return
+}
+
+func (s *Server) createEvent(w http.ResponseWriter, r *http.Request, u *User) {
+	req, rooms, start, end, ok := decodeEvent(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.validRooms(rooms) {
+		writeError(w, http.StatusBadRequest, "main.messages.error.validation")
+		return
+	}
+	if s.occupied(rooms, start, end, 0) != -1 {
+		writeError(w, http.StatusConflict, "timetable.timetableEvent.error.roomOccupied")
+		return
+	}
+
+	s.lastId++
+	s.events[s.lastId] = &Event{
+		Id:    s.lastId,
+		Owner: u.IDCode,
+		Name:  req.Name,
+		Start: start,
+		End:   end,
+		Rooms: rooms,
+	}
+
+	writeJSON(w, http.StatusOK, map[string]int{"id": s.lastId, "version": 0})
+}
+
+func (s *Server) updateEvent(w http.ResponseWriter, r *http.Request, u *User) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "main.messages.error.notFound")
+		return
+	}
+
+	req, rooms, start, end, ok := decodeEvent(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.events[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "main.messages.error.notFound")
+		return
+	}
+	if e.Owner != u.IDCode {
+		writeError(w, http.StatusForbidden, "main.messages.error.nopermission")
+		return
+	}
+	if e.Version != req.Version {
+		writeError(w, http.StatusConflict, "main.messages.error.modified")
+		return
+	}
+	if !s.validRooms(rooms) {
+		writeError(w, http.StatusBadRequest, "main.messages.error.validation")
+		return
+	}
+	if s.occupied(rooms, start, end, id) != -1 {
+		writeError(w, http.StatusConflict, "timetable.timetableEvent.error.roomOccupied")
+		return
+	}
+
+	e.Version++
+	e.Name = req.Name
+	e.Start, e.End = start, end
+	e.Rooms = rooms
+
+	writeJSON(w, http.StatusOK, map[string]int{"id": e.Id, "version": e.Version})
+}
+
+func (s *Server) deleteEvent(w http.ResponseWriter, r *http.Request, u *User) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "main.messages.error.notFound")
+		return
+	}
+	version, err := strconv.Atoi(r.URL.Query().Get("version"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "main.messages.error.validation")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.events[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "main.messages.error.notFound")
+		return
+	}
+	if e.Owner != u.IDCode {
+		writeError(w, http.StatusForbidden, "main.messages.error.nopermission")
+		return
+	}
+	if e.Version != version {
+		writeError(w, http.StatusConflict, "main.messages.error.modified")
+		return
+	}
+
+	delete(s.events, id)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) listRooms(w http.ResponseWriter, r *http.Request, u *User) {
+	from, err := time.Parse(time.RFC3339, r.URL.Query().Get("from"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "main.messages.error.validation")
+		return
+	}
+	dayStart := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	dayEnd := dayStart.AddDate(0, 0, 1)
+
+	type (
+		equipment struct {
+			Equipment      string `json:"equipment"`
+			EquipmentCount int    `json:"equipmentCount"`
+		}
+		room struct {
+			Id            int         `json:"id"`
+			RoomCode      string      `json:"roomCode"`
+			RoomName      string      `json:"roomName"`
+			BuildingCode  string      `json:"buildingCode"`
+			BuildingName  string      `json:"buildingName"`
+			Times         []string    `json:"times"`
+			Places        int         `json:"places"`
+			IsUsedInStudy bool        `json:"isUsedInStudy"`
+			Equipment     []equipment `json:"equipment"`
+		}
+	)
+
+	s.mu.Lock()
+	var rooms []room
+	for _, r := range s.rooms {
+		var busy []*Event
+		for _, e := range s.events {
+			if e.hasRoom(r.Id) && e.overlaps(dayStart, dayEnd) {
+				busy = append(busy, e)
+			}
+		}
+		slices.SortFunc(busy, func(a, b *Event) int { return a.Start.Compare(b.Start) })
+
+		times := []string{}
+		for _, e := range busy {
+			times = append(times, e.Start.Format("15:04")+" - "+e.End.Format("15:04"))
+		}
+
+		equipments := []equipment{}
+		for code, count := range r.Equipment {
+			equipments = append(equipments, equipment{Equipment: code, EquipmentCount: count})
+		}
+		slices.SortFunc(equipments, func(a, b equipment) int { return strings.Compare(a.Equipment, b.Equipment) })
+
+		rooms = append(rooms, room{
+			Id:            r.Id,
+			RoomCode:      r.Code,
+			RoomName:      r.Name,
+			BuildingCode:  r.BuildingName,
+			BuildingName:  r.BuildingName,
+			Times:         times,
+			Places:        r.Places,
+			IsUsedInStudy: !r.NotInStudy,
+			Equipment:     equipments,
+		})
+	}
+	s.mu.Unlock()
+
+	page(w, r, rooms)
+}
+
+func (s *Server) classifiers(w http.ResponseWriter, r *http.Request) {
+	type classifier struct {
+		Code   string `json:"code"`
+		NameEt string `json:"nameEt"`
+	}
+
+	classifiers := []classifier{}
+	if r.URL.Query().Get("mainClassCode") == "SEADMED" {
+		s.mu.Lock()
+		for code, name := range s.equipment {
+			classifiers = append(classifiers, classifier{Code: code, NameEt: name})
+		}
+		s.mu.Unlock()
+	}
+
+	writeJSON(w, http.StatusOK, classifiers)
+}
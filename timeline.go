@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jtagcat/teinetahvel/tahvel"
+	ginutil "github.com/jtagcat/util/gin"
+	"go.etcd.io/bbolt"
+)
+
+// shown part of the day, widened by bookings outside it
+const (
+	TIMELINE_FROM  = 7 * time.Hour
+	TIMELINE_UNTIL = 22 * time.Hour
+	TIMELINE_CELL  = 30 * time.Minute // free time is clickable in cells
+)
+
+type (
+	timelineRow struct {
+		Room   tahvel.Room
+		Blocks []timelineBlock
+	}
+	timelineBlock struct {
+		Left, Width string // % of the timeline
+		Kind        string // busy, own, free, past
+		Title       string
+		Href        string // free only
+	}
+	timelineTick struct {
+		Left  string
+		Label string
+	}
+)
+
+// timeline lays out rooms on view.
+// own are the user's bookings, by room Id.
+func timeline(rooms []tahvel.Room, own map[int][]tahvel.Interval, view tahvel.Interval, now time.Time, length time.Duration) (rows []timelineRow) {
+	percent := func(t time.Time) float64 {
+		return float64(t.Sub(view.Start)) / float64(view.Duration()) * 100
+	}
+	block := func(iv tahvel.Interval, kind, title, href string) timelineBlock {
+		iv = iv.Intersect(view)
+		return timelineBlock{
+			Left:  fmt.Sprintf("%.2f", percent(iv.Start)),
+			Width: fmt.Sprintf("%.2f", percent(iv.End)-percent(iv.Start)),
+			Kind:  kind, Title: title, Href: href,
+		}
+	}
+
+	for _, r := range rooms {
+		row := timelineRow{Room: r}
+
+		for _, busy := range r.Busy {
+			if !busy.Overlaps(view) {
+				continue
+			}
+
+			kind := "busy"
+			if slices.ContainsFunc(own[r.Id], busy.Overlaps) {
+				kind = "own"
+			}
+			row.Blocks = append(row.Blocks, block(busy, kind, busy.String(), ""))
+		}
+
+		for _, free := range r.Free {
+			free = free.Intersect(view)
+
+			// cells aligned to the clock, first and last may be partial
+			for start := free.Start; start.Before(free.End); {
+				end := start.Truncate(TIMELINE_CELL).Add(TIMELINE_CELL)
+				if end.After(free.End) {
+					end = free.End
+				}
+				cell := tahvel.Interval{Start: start, End: end}
+				start = end
+
+				if !cell.End.After(now) {
+					row.Blocks = append(row.Blocks, block(cell, "past", "", ""))
+					continue
+				}
+
+				booking := tahvel.Interval{Start: cell.Start}
+				if soonest := now.Truncate(5 * time.Minute).Add(5 * time.Minute); booking.Start.Before(soonest) {
+					booking.Start = soonest
+				}
+				booking.End = booking.Start.Add(length)
+				if !booking.Start.Before(free.End) {
+					row.Blocks = append(row.Blocks, block(cell, "past", "", ""))
+					continue
+				}
+				if booking.End.After(free.End) {
+					booking.End = free.End
+				}
+
+				href := "/book?" + url.Values{
+					"id":    {strconv.Itoa(r.Id)},
+					"date":  {cell.Start.Format("2006-01-02")},
+					"start": {booking.StartClock()},
+					"stop":  {booking.EndClock()},
+				}.Encode()
+				row.Blocks = append(row.Blocks, block(cell, "free", "Broneeri "+booking.String(), href))
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return
+}
+
+// timelineView is TIMELINE_FROM to TIMELINE_UNTIL on day,
+// widened to include rooms' bookings.
+func timelineView(day time.Time, rooms []tahvel.Room) tahvel.Interval {
+	whole := tahvel.Day(day)
+	view := tahvel.Interval{Start: whole.Start.Add(TIMELINE_FROM), End: whole.Start.Add(TIMELINE_UNTIL)}
+
+	for _, r := range rooms {
+		for _, busy := range r.Busy {
+			busy = busy.Intersect(whole)
+			if busy.Start.Before(view.Start) {
+				view.Start = busy.Start.Truncate(time.Hour)
+			}
+			if busy.End.After(view.End) {
+				view.End = busy.End
+			}
+		}
+	}
+
+	return view
+}
+
+func timelineTicks(view tahvel.Interval) (ticks []timelineTick) {
+	for t := view.Start.Truncate(time.Hour); t.Before(view.End); t = t.Add(time.Hour) {
+		if t.Before(view.Start) {
+			continue
+		}
+
+		ticks = append(ticks, timelineTick{
+			Left:  fmt.Sprintf("%.2f", float64(t.Sub(view.Start))/float64(view.Duration())*100),
+			Label: t.Format("15"),
+		})
+	}
+
+	return
+}
+
+// ownBookings are the user's bookings on date, by room Id.
+func ownBookings(ctx context.Context, t *tahvel.Tahvel, date time.Time) (map[int][]tahvel.Interval, error) {
+	bookings, err := t.Bookings(ctx, date)
+	if err != nil {
+		return nil, err
+	}
+
+	own := make(map[int][]tahvel.Interval)
+	for _, b := range bookings {
+		if b.DateStr != date.Format("2006-01-02") {
+			continue
+		}
+		for _, id := range b.RoomIds() {
+			own[id] = append(own[id], b.Time)
+		}
+	}
+
+	return own, nil
+}
+
+func timelineHandlers(gctx context.Context, r *gin.Engine, db *bbolt.DB, client *tahvel.Client) {
+	r.GET("/timeline", ginutil.HandlerWithErr(func(c *gin.Context, g *ginutil.Context) (int, string) {
+		if !authed(c) {
+			return g.Redirect(http.StatusTemporaryRedirect, "/")
+		}
+
+		t := client.Session(g.Cookie("session"))
+		ctx, cancel := context.WithTimeout(gctx, 20*time.Second)
+		defer cancel()
+
+		user, status, errStr := sessionUser(ctx, c, db, t)
+		if user == nil {
+			return status, errStr
+		}
+
+		now := client.Now()
+
+		date := tahvel.Day(now).Start
+		if dateS := c.Query("date"); dateS != "" {
+			var err error
+			if date, err = client.Date(dateS); err != nil {
+				return http.StatusBadRequest, "Vigane kuupäev"
+			}
+		}
+
+		length, err := searchLength(c.DefaultQuery("length", "60"))
+		if err != nil || length == 0 {
+			return http.StatusBadRequest, "Vigane kestus"
+		}
+
+		rooms, err := t.GetRooms(ctx, date)
+		if err != nil {
+			return upstreamError(c, "listing rooms", err)
+		}
+		rooms = tahvel.UsableRooms(db, rooms, user.Roles, searchNeeds(c.Request.URL.Query()))
+
+		own, err := ownBookings(ctx, t, date)
+		if err != nil {
+			return upstreamError(c, "listing bookings", err)
+		}
+
+		view := timelineView(date, rooms)
+
+		return g.HTML(http.StatusOK, "timeline.html", gin.H{
+			"rows":  timeline(rooms, own, view, now, length),
+			"ticks": timelineTicks(view),
+
+			"date":       date.Format("2006-01-02"),
+			"prevDate":   date.AddDate(0, 0, -1).Format("2006-01-02"),
+			"nextDate":   date.AddDate(0, 0, 1).Format("2006-01-02"),
+			"today":      now.Format("2006-01-02"),
+			"maxDate":    now.AddDate(0, 0, client.BookingHorizon()).Format("2006-01-02"),
+			"length":     int(length.Minutes()),
+			"needsPiano": c.Query("needsPiano") == "needsPiano",
+		})
+	}))
+
+	r.GET("/room/:id", ginutil.HandlerWithErr(func(c *gin.Context, g *ginutil.Context) (int, string) {
+		if !authed(c) {
+			return g.Redirect(http.StatusTemporaryRedirect, "/")
+		}
+
+		t := client.Session(g.Cookie("session"))
+		ctx, cancel := context.WithTimeout(gctx, 20*time.Second)
+		defer cancel()
+
+		user, status, errStr := sessionUser(ctx, c, db, t)
+		if user == nil {
+			return status, errStr
+		}
+
+		id, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			return http.StatusBadRequest, "Vigane ruum"
+		}
+
+		now := client.Now()
+
+		date := tahvel.Day(now).Start
+		if dateS := c.Query("date"); dateS != "" {
+			if date, err = client.Date(dateS); err != nil {
+				return http.StatusBadRequest, "Vigane kuupäev"
+			}
+		}
+
+		length, err := searchLength(c.DefaultQuery("length", "60"))
+		if err != nil || length == 0 {
+			return http.StatusBadRequest, "Vigane kestus"
+		}
+
+		rooms, err := t.GetRooms(ctx, date)
+		if err != nil {
+			return upstreamError(c, "listing rooms", err)
+		}
+		i := slices.IndexFunc(rooms, func(r tahvel.Room) bool { return r.Id == id })
+		if i == -1 {
+			return http.StatusNotFound, "Ruumi ei leitud"
+		}
+		room := rooms[i]
+		access := room.HasAccess(db, user.Roles)
+
+		equipment, err := client.GetEquipment(ctx)
+		if err != nil {
+			return upstreamError(c, "listing equipment", err)
+		}
+		var roomEquipment []string
+		for _, e := range room.Equipment {
+			roomEquipment = append(roomEquipment, fmt.Sprintf("%s × %d", strings.TrimPrefix(equipment[e.Equipment], "_"), e.EquipmentCount))
+		}
+
+		own, err := ownBookings(ctx, t, date)
+		if err != nil {
+			return upstreamError(c, "listing bookings", err)
+		}
+
+		view := timelineView(date, []tahvel.Room{room})
+
+		return g.HTML(http.StatusOK, "room.html", gin.H{
+			"room":      room,
+			"access":    access,
+			"favourite": favouriteSet(db, user.UserId)[room.Id],
+			"equipment": roomEquipment,
+
+			"rows":  timeline([]tahvel.Room{room}, own, view, now, length),
+			"ticks": timelineTicks(view),
+
+			"date":     date.Format("2006-01-02"),
+			"prevDate": date.AddDate(0, 0, -1).Format("2006-01-02"),
+			"nextDate": date.AddDate(0, 0, 1).Format("2006-01-02"),
+			"today":    now.Format("2006-01-02"),
+			"maxDate":  now.AddDate(0, 0, client.BookingHorizon()).Format("2006-01-02"),
+			"length":   int(length.Minutes()),
+		})
+	}))
+}
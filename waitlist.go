@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jtagcat/teinetahvel/tahvel"
+	ginutil "github.com/jtagcat/util/gin"
+	"github.com/jtagcat/util/std"
+	"github.com/rs/xid"
+	"go.etcd.io/bbolt"
+)
+
+const (
+	WAITLIST_INTERVAL = 2 * time.Minute
+	SMTP_TIMEOUT      = 30 * time.Second // whole conversation
+)
+
+// e-mail notifications are sent if SMTP_ADDR is set
+var (
+	SMTP_ADDR     = os.Getenv("SMTP_ADDR") // host:port
+	SMTP_USER     = os.Getenv("SMTP_USER")
+	SMTP_PASSWORD = os.Getenv("SMTP_PASSWORD")
+	SMTP_FROM     = os.Getenv("SMTP_FROM")
+	PUBLIC_URL    = strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/") // for links in e-mails
+)
+
+var (
+	waitlistMu sync.Mutex // avoids booking the same entry twice
+	notifyMu   sync.Mutex // avoids notifying twice, sending is outside waitlistMu
+)
+
+// waitlistEntry is a search re-run by runWaitlist,
+// until it finds a room or the searched time has passed.
+type waitlistEntry struct {
+	Id       string
+	UserId   int
+	Date     string // 2006-01-02
+	Query    string // as in /search, without date
+	AutoBook bool
+	Email    string // notified at, if set
+
+	Found    string // "D107 10:00 - 11:00", empty while waiting
+	Booked   bool   // Found was booked with AutoBook
+	Notified bool   // about Found, or nothing to notify
+	Expired  bool
+	Error    string // of the last try, waiting (or notifying) continues
+}
+
+func (w *waitlistEntry) values() url.Values {
+	q, _ := url.ParseQuery(w.Query)
+	return q
+}
+
+func (w *waitlistEntry) SearchURL() string {
+	q := w.values()
+	q.Set("date", w.Date)
+
+	return "/search?" + q.Encode()
+}
+
+func (w *waitlistEntry) Summary() string {
+	return searchSummary(w.values())
+}
+
+func (w *waitlistEntry) waiting() bool {
+	return w.Found == "" && !w.Expired
+}
+
+// check re-runs the search, booking on success. Notifying is left to notifyWaitlist.
+func (w *waitlistEntry) check(ctx context.Context, db *bbolt.DB, t *tahvel.Tahvel, user *tahvel.User) error {
+	date, err := t.Date(w.Date)
+	if err != nil {
+		return err
+	}
+
+	candidates, err := searchCandidates(ctx, db, t, user, w.values(), date)
+	if err != nil || len(candidates) == 0 {
+		return err
+	}
+
+	if !w.AutoBook {
+		w.found(candidates[0].Room.OnlyCode() + " " + candidates[0].Interval.String())
+		return nil
+	}
+
+	booked, err := bookFirst(ctx, t, candidates)
+	if err != nil {
+		if errors.Is(err, tahvel.ErrOccupied) || errors.Is(err, tahvel.ErrForbidden) {
+			return nil // taken again, keep waiting
+		}
+		return err
+	}
+
+	w.Booked = true
+	w.found(booked.Room.OnlyCode() + " " + booked.Interval.String())
+	return nil
+}
+
+func (w *waitlistEntry) found(found string) {
+	w.Found = found
+	w.Notified = SMTP_ADDR == "" || w.Email == ""
+}
+
+func (w *waitlistEntry) notify() error {
+	subject := "Ruum vabanes: " + w.Found
+	body := fmt.Sprintf("%s vabanes %s.\r\nOtsing: %s\r\n", w.Found, w.Date, w.Summary())
+	if w.Booked {
+		subject = "Broneeritud: " + w.Found
+		body = fmt.Sprintf("Broneerisin sulle %s, %s.\r\nOtsing: %s\r\n", w.Found, w.Date, w.Summary())
+	} else if PUBLIC_URL != "" {
+		body += "Broneeri: " + PUBLIC_URL + w.SearchURL() + "\r\n"
+	}
+
+	msg := "From: " + SMTP_FROM + "\r\n" +
+		"To: " + w.Email + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + body
+
+	return sendMail(w.Email, []byte(msg))
+}
+
+// sendMail is smtp.SendMail, within SMTP_TIMEOUT.
+func sendMail(to string, msg []byte) error {
+	host, _, _ := strings.Cut(SMTP_ADDR, ":")
+
+	conn, err := net.DialTimeout("tcp", SMTP_ADDR, SMTP_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(SMTP_TIMEOUT)); err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if SMTP_USER != "" {
+		if err := c.Auth(smtp.PlainAuth("", SMTP_USER, SMTP_PASSWORD, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(SMTP_FROM); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	wc, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := wc.Write(msg); err != nil {
+		return err
+	}
+	if err := wc.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// expired is when the searched time has passed.
+func (w *waitlistEntry) expired(client *tahvel.Client) bool {
+	date, err := client.Date(w.Date)
+	if err != nil {
+		return true
+	}
+
+	want, _, err := bookableSearch(w.values(), date)
+	if err != nil {
+		return true
+	}
+	if want.End.IsZero() {
+		want.End = tahvel.Day(date).End
+	}
+
+	return !want.End.After(client.Now())
+}
+
+//
+
+func waitlistEntries(db *bbolt.DB) ([]waitlistEntry, error) {
+	return listJobs[waitlistEntry](db, "waitlist")
+}
+
+func userWaitlist(db *bbolt.DB, userId int) ([]waitlistEntry, error) {
+	entries, err := waitlistEntries(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(entries, func(w waitlistEntry) bool { return w.UserId != userId }), nil
+}
+
+//
+
+func runWaitlist(ctx context.Context, db *bbolt.DB, client *tahvel.Client) {
+	checkWaitlist(ctx, db, client)
+	notifyWaitlist(db)
+}
+
+func checkWaitlist(gctx context.Context, db *bbolt.DB, client *tahvel.Client) {
+	waitlistMu.Lock()
+	defer waitlistMu.Unlock()
+
+	entries, err := waitlistEntries(db)
+	if err != nil {
+		slog.Error("listing waitlist", std.SlogErr(err))
+		return
+	}
+
+	now := client.Now()
+	today := now.Format("2006-01-02")
+	sessions := newJobSessions(db, client)
+
+	for _, entry := range entries {
+		if !entry.waiting() {
+			// kept for the user to see until the day is over
+			if entry.Date < today {
+				if err := deleteJob(db, "waitlist", entry.UserId, entry.Id); err != nil {
+					slog.Error("deleting waitlist entry", slog.String("entry", entry.Id), std.SlogErr(err))
+				}
+			}
+			continue
+		}
+
+		if entry.expired(client) {
+			entry.Expired = true
+			if err := putJob(db, "waitlist", entry.Id, entry, true); err != nil {
+				slog.Error("saving waitlist entry", slog.String("entry", entry.Id), std.SlogErr(err))
+			}
+			if err := forgetSession(db, entry.UserId); err != nil {
+				slog.Error("forgetting session", std.SlogErr(err))
+			}
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(gctx, time.Minute)
+		t, user := sessions.get(ctx, entry.UserId)
+
+		if user == nil {
+			entry.Error = SESSION_EXPIRED
+		} else if err := entry.check(ctx, db, t, user); err != nil {
+			slog.Warn("checking waitlist", slog.String("entry", entry.Id), std.SlogErr(err))
+			entry.Error = err.Error()
+
+			if errors.Is(err, tahvel.ErrSessionExpired) {
+				sessions.expired(entry.UserId)
+				entry.Error = SESSION_EXPIRED
+			}
+		} else {
+			entry.Error = ""
+		}
+
+		cancel()
+
+		if entry.Found != "" {
+			slog.Info("waitlist found", slog.String("entry", entry.Id), slog.String("found", entry.Found), slog.Bool("booked", entry.Booked))
+		}
+		if err := putJob(db, "waitlist", entry.Id, entry, true); err != nil {
+			slog.Error("saving waitlist entry", slog.String("entry", entry.Id), std.SlogErr(err))
+		}
+		if !entry.waiting() {
+			if err := forgetSession(db, entry.UserId); err != nil {
+				slog.Error("forgetting session", std.SlogErr(err))
+			}
+		}
+	}
+}
+
+// notifyWaitlist sends notifications about found rooms, failed ones are retried on the next run.
+// Skipped while a previous run is still sending.
+func notifyWaitlist(db *bbolt.DB) {
+	if !notifyMu.TryLock() {
+		return
+	}
+	defer notifyMu.Unlock()
+
+	entries, err := waitlistEntries(db)
+	if err != nil {
+		slog.Error("listing waitlist", std.SlogErr(err))
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.Found == "" || entry.Notified {
+			continue
+		}
+
+		if err := entry.notify(); err != nil {
+			slog.Warn("notifying waitlist", slog.String("entry", entry.Id), std.SlogErr(err))
+			entry.Error = "E-kirja saatmine ebaõnnestus, proovin uuesti."
+		} else {
+			entry.Notified, entry.Error = true, ""
+		}
+
+		if err := putJob(db, "waitlist", entry.Id, entry, true); err != nil {
+			slog.Error("saving waitlist entry", slog.String("entry", entry.Id), std.SlogErr(err))
+		}
+	}
+}
+
+//
+
+func waitlistHandlers(gctx context.Context, r *gin.Engine, db *bbolt.DB, client *tahvel.Client) {
+	r.POST("/waitlist", ginutil.HandlerWithErr(func(c *gin.Context, g *ginutil.Context) (int, string) {
+		if !authed(c) {
+			return g.Redirect(http.StatusTemporaryRedirect, "/")
+		}
+
+		t := client.Session(g.Cookie("session"))
+		ctx, cancel := context.WithTimeout(gctx, 5*time.Second)
+		defer cancel()
+
+		user, status, errStr := sessionUser(ctx, c, db, t)
+		if user == nil {
+			return status, errStr
+		}
+
+		query, err := url.ParseQuery(c.PostForm("query"))
+		if err != nil {
+			return http.StatusBadRequest, "Vigane otsing"
+		}
+
+		entry := waitlistEntry{
+			Id:       xid.New().String(),
+			UserId:   user.UserId,
+			Date:     c.PostForm("date"),
+			Query:    presetQuery(query),
+			AutoBook: c.PostForm("autoBook") == "1",
+			Email:    strings.TrimSpace(c.PostForm("email")),
+		}
+
+		date, err := client.Date(entry.Date)
+		if err != nil {
+			return http.StatusBadRequest, "Vigane kuupäev"
+		}
+		if err := client.CheckHorizon(date); err != nil {
+			return horizonError(client)
+		}
+		if _, _, err := bookableSearch(query, date); err != nil {
+			return http.StatusBadRequest, err.Error()
+		}
+		if entry.expired(client) {
+			return http.StatusBadRequest, "Otsitud aeg on möödas"
+		}
+
+		if entry.Email != "" {
+			if SMTP_ADDR == "" {
+				return http.StatusBadRequest, "E-posti teavitused pole seadistatud, jäta aadress tühjaks"
+			}
+
+			addr, err := mail.ParseAddress(entry.Email)
+			if err != nil {
+				return http.StatusBadRequest, "Vigane e-posti aadress"
+			}
+			entry.Email = addr.Address
+		}
+		settings := getSettings(db, user.UserId)
+		if settings.Email != entry.Email {
+			settings.Email = entry.Email
+			if err := putSettings(db, user.UserId, settings); err != nil {
+				slog.Warn("saving settings", std.SlogErr(err))
+			}
+		}
+
+		if err := putJob(db, "waitlist", entry.Id, entry, false); err != nil {
+			return http.StatusInternalServerError, err.Error()
+		}
+		if err := rememberSession(db, user.UserId, t.Session); err != nil {
+			return http.StatusInternalServerError, err.Error()
+		}
+
+		go runWaitlist(gctx, db, client)
+
+		return g.Redirect(http.StatusSeeOther, entry.SearchURL())
+	}))
+
+	r.GET("/waitlist/delete", ginutil.HandlerWithErr(func(c *gin.Context, g *ginutil.Context) (int, string) {
+		if !authed(c) {
+			return g.Redirect(http.StatusTemporaryRedirect, "/")
+		}
+
+		t := client.Session(g.Cookie("session"))
+		ctx, cancel := context.WithTimeout(gctx, 5*time.Second)
+		defer cancel()
+
+		user, status, errStr := sessionUser(ctx, c, db, t)
+		if user == nil {
+			return status, errStr
+		}
+
+		if err := deleteJob(db, "waitlist", user.UserId, c.Query("id")); err != nil {
+			return http.StatusNotFound, err.Error()
+		}
+		if err := forgetSession(db, user.UserId); err != nil {
+			slog.Error("forgetting session", std.SlogErr(err))
+		}
+
+		return g.Redirect(http.StatusTemporaryRedirect, back(c, "/search"))
+	}))
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// fakeSMTP accepts mail until closed, counting messages.
+func fakeSMTP(t *testing.T) (addr string, sent <-chan string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	messages := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			r := bufio.NewReader(conn)
+			reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+			reply("220 fake")
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					break
+				}
+
+				switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+				case "DATA":
+					reply("354 go on")
+					var msg strings.Builder
+					for {
+						line, err := r.ReadString('\n')
+						if err != nil || line == ".\r\n" {
+							break
+						}
+						msg.WriteString(line)
+					}
+					messages <- msg.String()
+					reply("250 ok")
+				case "QUIT":
+					reply("221 bye")
+				default:
+					reply("250 ok")
+				}
+			}
+			conn.Close()
+		}
+	}()
+
+	return l.Addr().String(), messages
+}
+
+// setSMTP sets SMTP_ADDR for the test, in step with background runs.
+func setSMTP(t *testing.T, addr string) {
+	set := func(addr string) {
+		waitlistMu.Lock()
+		notifyMu.Lock()
+		SMTP_ADDR = addr
+		notifyMu.Unlock()
+		waitlistMu.Unlock()
+	}
+
+	set(addr)
+	t.Cleanup(func() { set("") })
+}
+
+func TestWaitlistNotifyRetries(t *testing.T) {
+	db := newTestApp(t).db
+
+	entry := waitlistEntry{Id: "a", UserId: testUserId, Date: "2026-10-20", Email: "mari@example.com"}
+	setSMTP(t, "127.0.0.1:1") // refused
+
+	entry.found("D108 10:00 - 11:00")
+	if err := putJob(db, "waitlist", entry.Id, entry, false); err != nil {
+		t.Fatal(err)
+	}
+
+	notifyWaitlist(db)
+	entries, _ := waitlistEntries(db)
+	if entries[0].Notified || entries[0].Error == "" {
+		t.Fatalf("failed notification not kept for retrying: %+v", entries[0])
+	}
+
+	addr, sent := fakeSMTP(t)
+	setSMTP(t, addr)
+
+	notifyWaitlist(db)
+	entries, _ = waitlistEntries(db)
+	if !entries[0].Notified || entries[0].Error != "" {
+		t.Fatalf("not notified on retry: %+v", entries[0])
+	}
+	if msg := <-sent; !strings.Contains(msg, "D108 10:00 - 11:00") {
+		t.Errorf("sent %q", msg)
+	}
+
+	notifyWaitlist(db)
+	select {
+	case msg := <-sent:
+		t.Errorf("notified again: %q", msg)
+	default:
+	}
+}
+
+func TestWaitlistEmail(t *testing.T) {
+	a := newTestApp(t)
+	a.login()
+
+	form := func(email string) url.Values {
+		query := url.Values{"startTime": {"10:00"}, "stopTime": {"11:00"}}
+		return url.Values{"date": {a.date}, "query": {query.Encode()}, "email": {email}}
+	}
+
+	// not configured
+	expectStatus(t, a.post("/waitlist", form("mari@example.com")), http.StatusBadRequest)
+
+	setSMTP(t, "127.0.0.1:1")
+
+	for _, email := range []string{"mari", "mari@example.com\r\nBcc: x@example.com", "a@b@c"} {
+		expectStatus(t, a.post("/waitlist", form(email)), http.StatusBadRequest)
+	}
+	expectStatus(t, a.post("/waitlist", form("Mari <mari@example.com>")), http.StatusSeeOther)
+
+	entries, err := userWaitlist(a.db, testUserId)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("entries %v: %v", entries, err)
+	}
+	if entries[0].Email != "mari@example.com" {
+		t.Errorf("saved %q", entries[0].Email)
+	}
+}